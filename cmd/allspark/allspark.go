@@ -1,29 +1,50 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sparkcorp/allspark/pkg/api"
 	"github.com/sparkcorp/allspark/pkg/conf"
 	"github.com/sparkcorp/allspark/pkg/controller"
+	"github.com/sparkcorp/allspark/pkg/egress"
 	"github.com/sparkcorp/allspark/pkg/handlers"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/metrics"
+	"github.com/sparkcorp/allspark/pkg/signals"
 	"github.com/sparkcorp/allspark/pkg/version"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	leaderElectionLock = "allspark-controller-manager"
+	configReloadPeriod = 10 * time.Second
+	// maxHeaderBytes bounds the headers of the ini-server requests
+	maxHeaderBytes = 64 << 10
 )
 
 var (
 	showVersionAndExit bool
+	configFile         string
 	cfg                conf.Config
 )
 
@@ -42,6 +63,15 @@ func cmd() *cobra.Command {
 			}
 			version.Print()
 
+			if configFile != "" {
+				if err := conf.LoadFile(configFile, conf.KindController, &cfg, cmd.Flags()); err != nil {
+					return err
+				}
+			}
+			if err := cfg.Validate(conf.KindController); err != nil {
+				return err
+			}
+
 			kubecli := kubernetes.NewForConfigOrDie(api.NewKubernetesConfig(&cfg))
 			v, err := kubecli.Discovery().ServerVersion()
 			if err != nil {
@@ -52,53 +82,328 @@ func cmd() *cobra.Command {
 
 			sharedInformers := informers.NewSharedInformerFactoryWithOptions(
 				kubecli,
-				time.Second*30,
+				cfg.InformerResync.Duration,
 				informers.WithNamespace(cfg.WatchNamespace),
 			)
+			// frps pods, secrets and kubelet services live in the namespace of the controller
+			systemInformers := informers.NewSharedInformerFactoryWithOptions(
+				kubecli,
+				cfg.InformerResync.Duration,
+				informers.WithNamespace(os.Getenv("POD_NAMESPACE")),
+			)
+			// only the frpc and frps pods are cached, not every pod of the cluster
+			onlyTunnelPods := informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+				o.LabelSelector = controller.PodSelector
+			})
+			podInformers := informers.NewSharedInformerFactoryWithOptions(
+				kubecli,
+				cfg.InformerResync.Duration,
+				informers.WithNamespace(cfg.WatchNamespace),
+				onlyTunnelPods,
+			)
+			systemPodInformers := informers.NewSharedInformerFactoryWithOptions(
+				kubecli,
+				cfg.InformerResync.Duration,
+				informers.WithNamespace(os.Getenv("POD_NAMESPACE")),
+				onlyTunnelPods,
+			)
 
-			stopc := wait.NeverStop
+			stopc := signals.SetupSignalHandler()
+			if configFile != "" {
+				go conf.Watch(configFile, conf.KindController, &cfg, configReloadPeriod, stopc)
+			}
+			// the metrics provider must be set before creating the task queues
+			metrics.RegisterController()
 			asc := controller.NewASController(
 				kubecli,
 				sharedInformers.Extensions().V1beta1().Ingresses(),
 				sharedInformers.Core().V1().Namespaces(),
-				sharedInformers.Core().V1().Services(),
+				podInformers.Core().V1().Pods(),
 				sharedInformers.Core().V1().Nodes(),
+				systemInformers.Core().V1().Services(),
+				systemPodInformers.Core().V1().Pods(),
+				systemInformers.Core().V1().Secrets(),
 				&cfg,
 			)
-			go asc.Run(1, stopc)
+			prometheus.MustRegister(controller.NewCollector(asc))
+			ctrlStarted, ctrlDone := make(chan struct{}), make(chan struct{})
+			runController := func(stop <-chan struct{}) {
+				close(ctrlStarted)
+				defer close(ctrlDone)
+				asc.Run(1, stop)
+			}
+			if cfg.LeaderElect {
+				// only the leader runs the workers, the ini-server
+				// is served by every replica from its informer caches
+				le, err := newLeaderElectionConfig(kubecli, stopc, func(stop <-chan struct{}) {
+					runController(mergeStop(stop, stopc))
+				})
+				if err != nil {
+					glog.Fatalf("failed configuring leader election: %v", err)
+				}
+				go leaderelection.RunOrDie(*le)
+			} else {
+				go runController(stopc)
+			}
 
 			sharedInformers.Start(stopc)
-			if !cache.WaitForCacheSync(stopc, asc.IngressHasSynced, asc.ServiceHasSynced) {
+			systemInformers.Start(stopc)
+			podInformers.Start(stopc)
+			systemPodInformers.Start(stopc)
+			synced := []cache.InformerSynced{asc.IngressHasSynced, asc.ServiceHasSynced, asc.NodeHasSynced, asc.SystemPodHasSynced}
+			var healthSrv *http.Server
+			if cfg.HealthAddress != "" {
+				healthSrv = serveHealth(cfg.HealthAddress, synced...)
+			}
+			if !cache.WaitForCacheSync(stopc, synced...) {
 				glog.Fatalf("Receive shutdown on cache sync.")
 			}
 			common := &api.FrpcCommon{
 				AdminAddress:  "0.0.0.0",
-				AdminPort:     7400,
+				AdminPort:     cfg.FRPCAdminPort,
 				ServerAddress: cfg.FRPSAddress,
-				// ServerPort:    cfg.FRPSPort,
+				Token:         cfg.FRPSToken,
 			}
 			h := handlers.New(asc, common)
+			h.AddEventHandlers(
+				sharedInformers.Extensions().V1beta1().Ingresses().Informer(),
+				sharedInformers.Core().V1().Namespaces().Informer(),
+				systemInformers.Core().V1().Services().Informer(),
+			)
 			r := mux.NewRouter()
-			r.HandleFunc("/v1/namespaces/{namespace}/ingress/{name}", h.IngressToIni)
+			h.Register(r)
+			if healthSrv == nil {
+				r.Handle("/metrics", promhttp.Handler())
+			}
+
+			denied, err := httputil.ParseCIDRs(cfg.DenyCIDRs)
+			if err != nil {
+				glog.Fatalf("failed parsing denied networks: %v", err)
+			}
+			trustedProxies, err := httputil.ParseCIDRs(cfg.TrustedProxyCIDRs)
+			if err != nil {
+				glog.Fatalf("failed parsing trusted proxies: %v", err)
+			}
+			clientIP := httputil.ClientIP(cfg.ClientIPHeader, trustedProxies)
+			h.LimitWatches(clientIP, cfg.MaxWatchesPerClient)
+			namespaceExists := func(name string) bool {
+				_, err := asc.NamespaceLister.Get(name)
+				return err == nil
+			}
+			srv := &http.Server{
+				Addr:           cfg.ListenAddress,
+				MaxHeaderBytes: maxHeaderBytes,
+				Handler: httputil.Chain(r,
+					httputil.RequestID,
+					httputil.AccessLog,
+					httputil.Recover,
+					httputil.Denylist(denied, clientIP),
+					httputil.RateLimit(
+						httputil.Limit{QPS: cfg.RateLimitPerClient, Burst: cfg.RateLimitPerClientBurst},
+						httputil.Limit{QPS: cfg.RateLimitPerNamespace, Burst: cfg.RateLimitPerNamespaceBurst},
+						clientIP,
+						namespaceExists,
+					),
+					httputil.MaxBytes(cfg.MaxRequestBytes),
+					httputil.Deadline(cfg.RequestTimeout.Duration, httputil.IsStream),
+					httputil.CORS(cfg.CORSAllowedOrigins),
+				),
+			}
+			// watches never go idle, they're closed when shutting down
+			srv.RegisterOnShutdown(h.CloseWatches)
+			if cfg.TLSCertFile != "" {
+				srv.TLSConfig, err = httputil.ServerTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+				if err != nil {
+					glog.Fatalf("failed configuring ini-server tls: %v", err)
+				}
+			}
+			// the egress proxy resolves nodes and frps pods from the synced caches
+			var egressSrv *http.Server
+			if cfg.EgressAddress != "" || cfg.EgressUDSName != "" {
+				egressSrv, err = serveEgress(asc)
+				if err != nil {
+					glog.Fatalf("failed serving egress proxy: %v", err)
+				}
+			}
+			go func() {
+				var err error
+				if srv.TLSConfig != nil {
+					glog.Infof("Listening to %s (https)", cfg.ListenAddress)
+					err = srv.ListenAndServeTLS("", "")
+				} else {
+					glog.Infof("Listening to %s", cfg.ListenAddress)
+					err = srv.ListenAndServe()
+				}
+				if err != nil && err != http.ErrServerClosed {
+					glog.Fatalf("failed serving ini-server: %v", err)
+				}
+			}()
+			<-stopc
 
-			glog.Info("Listening to port :3500")
-			return http.ListenAndServe(":3500", r)
+			// a single deadline bounds the servers and the workers of the controller
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+			defer cancel()
+			// drain in-flight requests
+			if err := srv.Shutdown(ctx); err != nil {
+				glog.Warningf("failed shutting down ini-server: %v", err)
+			}
+			if egressSrv != nil {
+				// hijacked tunnels aren't tracked by Shutdown, they end with the process
+				if err := egressSrv.Shutdown(ctx); err != nil {
+					glog.Warningf("failed shutting down egress proxy: %v", err)
+				}
+			}
+			if healthSrv != nil {
+				if err := healthSrv.Shutdown(ctx); err != nil {
+					glog.Warningf("failed shutting down health server: %v", err)
+				}
+			}
+			select {
+			case <-ctrlStarted:
+				select {
+				case <-ctrlDone:
+				case <-ctx.Done():
+					glog.Warningf("timeout waiting for the controller to shut down")
+				}
+			default:
+				// not the leader, there's no worker to wait for
+			}
+			glog.Info("Shutdown completed")
+			return nil
 		},
 	}
 	c.Flags().BoolVar(&showVersionAndExit, "version", false, "Print version and exit.")
+	c.Flags().StringVar(&configFile, "config", "", "Path to a ControllerConfig file, flags set on the command line take precedence.")
 	c.Flags().StringVar(&cfg.KubeConfigPath, "kubeconfig", "", "Path to kubeconfig file.")
 	c.Flags().StringVar(&cfg.PublicMasterURL, "public-master-url", "", "The public address of the master url used by syncer.")
 	c.Flags().StringVar(&cfg.FRPSAddress, "frps-address", "", "The address of the FRP Server.")
-	// c.Flags().Int32Var(&cfg.FRPSPort, "frps-port", 7000, "The port of the FRP Server.")
 	c.Flags().StringVar(&cfg.FRPSToken, "frps-token", "", "FRPS token to establish trust with client.")
 	c.Flags().StringVar(&cfg.ContainerImage, "image", "quay.io/sandromello/frp:v0.20.0", "The FRP image used by this controller.")
+	c.Flags().StringVar(&cfg.FRPSAllowPorts, "frps-allow-ports", "", "The ports allowed to be bound by tcp proxies on each FRPS, e.g.: 2000-3000,3001.")
+	c.Flags().StringVar(&cfg.TenantBaseDomain, "tenant-base-domain", "", "The domain delegated to tenants, each tenant gets a wildcard subdomain: *.<tenant>.<tenant-base-domain>.")
+	c.Flags().StringVar(&cfg.DNSConfigMap, "dns-configmap", "kube-system/allspark-hosts", "The <namespace>/<name> of the config map to render the hosts file for CoreDNS, empty disables it.")
+	c.Flags().StringVar(&cfg.FRPSDashboardUser, "frps-dashboard-user", "admin", "The user of the FRPS dashboard api, a random password is generated for each tenant.")
+	c.Flags().StringVar(&cfg.EgressAddress, "egress-address", "", "The tcp address to serve the HTTP CONNECT egress proxy for the API Server, empty disables it.")
+	c.Flags().StringVar(&cfg.EgressUDSName, "egress-uds-name", "", "The unix socket to serve the egress proxy, takes precedence over --egress-address.")
+	c.Flags().StringVar(&cfg.EgressTLSCertFile, "egress-tls-cert-file", "", "The certificate served by the egress proxy on tcp.")
+	c.Flags().StringVar(&cfg.EgressTLSKeyFile, "egress-tls-key-file", "", "The private key of --egress-tls-cert-file.")
+	c.Flags().StringVar(&cfg.EgressClientCAFile, "egress-client-ca-file", "", "The CA bundle used to verify the client certificate of the API Server.")
+	c.Flags().Int64Var(&cfg.TenantStatusResync, "tenant-status-resync", 30, "Interval in seconds to collect the status of each tenant FRPS.")
 	c.Flags().StringVar(&cfg.FRPSNodeIP, "node-ip", "", "The IP of the node to expose FRPS ports.")
+	c.Flags().StringVar(&cfg.ListenAddress, "listen-address", ":3500", "The address to serve the ini-server.")
+	c.Flags().Int32Var(&cfg.FRPCAdminPort, "frpc-admin-port", 7400, "The port of the FRPC admin api.")
+	c.Flags().Int32Var(&cfg.PortRangeMin, "port-range-min", 20000, "The first port of the window allocated to FRPS services.")
+	c.Flags().Int32Var(&cfg.PortRangeMax, "port-range-max", 21000, "The last port of the window allocated to FRPS services.")
+	c.Flags().DurationVar(&cfg.InformerResync.Duration, "informer-resync", 30*time.Second, "The resync period of the informers.")
 	c.Flags().StringVar(&cfg.WatchNamespace, "watch-namespace", corev1.NamespaceAll, "Namespace to watch for Ingress. Default is to watch all namespaces")
+	c.Flags().BoolVar(&cfg.LeaderElect, "leader-elect", true, "Start a leader election client and gain leadership before running the controller workers.")
+	c.Flags().DurationVar(&cfg.LeaseDuration.Duration, "leader-elect-lease-duration", 15*time.Second, "The duration that non-leader candidates will wait to force acquire leadership.")
+	c.Flags().DurationVar(&cfg.RenewDeadline.Duration, "leader-elect-renew-deadline", 10*time.Second, "The duration that the acting leader will retry refreshing leadership before giving up.")
+	c.Flags().DurationVar(&cfg.RetryPeriod.Duration, "leader-elect-retry-period", 2*time.Second, "The duration the clients should wait between attempting acquisition and renewal of a leadership.")
+	c.Flags().StringVar(&cfg.TLSCertFile, "tls-cert-file", "", "The certificate to serve the ini-server over https, it's reloaded when the file changes.")
+	c.Flags().StringVar(&cfg.TLSKeyFile, "tls-key-file", "", "The private key of --tls-cert-file.")
+	c.Flags().StringVar(&cfg.TLSClientCAFile, "tls-client-ca-file", "", "The CA bundle to verify client certificates, clients without a valid certificate are rejected.")
+	c.Flags().StringVar(&cfg.HealthAddress, "health-address", ":3501", "The plaintext address to serve health checks and metrics, empty serves metrics on --listen-address.")
+	c.Flags().DurationVar(&cfg.RequestTimeout.Duration, "request-timeout", 30*time.Second, "The deadline of each request served by the ini-server, slower requests are answered 503 Timeout. Watches aren't bounded.")
+	c.Flags().Float64Var(&cfg.RateLimitPerClient, "rate-limit-per-client", 10, "The requests per second allowed for each client of the ini-server, 0 disables the limit.")
+	c.Flags().IntVar(&cfg.RateLimitPerClientBurst, "rate-limit-per-client-burst", 20, "The burst of requests allowed for each client of the ini-server.")
+	c.Flags().Float64Var(&cfg.RateLimitPerNamespace, "rate-limit-per-namespace", 20, "The requests per second allowed for each namespace of the ini-server, 0 disables the limit.")
+	c.Flags().IntVar(&cfg.RateLimitPerNamespaceBurst, "rate-limit-per-namespace-burst", 40, "The burst of requests allowed for each namespace of the ini-server.")
+	c.Flags().Int64Var(&cfg.MaxRequestBytes, "max-request-bytes", 1<<20, "The maximum size of a request body, 0 disables the limit.")
+	c.Flags().StringSliceVar(&cfg.DenyCIDRs, "deny-cidrs", nil, "The networks (or addresses) denied from calling the ini-server.")
+	c.Flags().StringVar(&cfg.ClientIPHeader, "client-ip-header", "", "The header identifying the client address when behind a proxy, e.g.: X-Forwarded-For. It requires --trusted-proxy-cidrs.")
+	c.Flags().StringSliceVar(&cfg.TrustedProxyCIDRs, "trusted-proxy-cidrs", nil, "The networks (or addresses) of the proxies trusted to set --client-ip-header.")
+	c.Flags().IntVar(&cfg.MaxWatchesPerClient, "max-watches-per-client", 10, "The watches each client of the ini-server could keep open at once, 0 disables the limit.")
+	c.Flags().StringVar(&cfg.SyncerVersionRange, "syncer-version-range", "", "The ini-sync versions supported, e.g.: '>=0.1.0 <0.2.0'. Empty allows the minor release of the controller, not newer.")
+	c.Flags().StringVar(&cfg.FRPVersionRange, "frp-version-range", ">=0.20.0 <0.21.0", "The frps and frpc versions supported, empty allows any version.")
+	c.Flags().StringSliceVar(&cfg.CORSAllowedOrigins, "cors-allowed-origins", nil, "The origins allowed to call the ini-server from a browser, '*' allows any origin.")
+	c.Flags().DurationVar(&cfg.ShutdownTimeout.Duration, "shutdown-timeout", 25*time.Second, "The time to wait for in-flight requests and syncs when shutting down.")
 	c.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 	return &c
 }
 
+// mergeStop returns a channel which is closed when any of the given channels is closed
+func mergeStop(a, b <-chan struct{}) <-chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return stop
+}
+
+// serveEgress starts the HTTP CONNECT proxy used by the egress selector of the API Server
+func serveEgress(resolver egress.Resolver) (*http.Server, error) {
+	l, err := egress.Listen(egress.Config{
+		Address:      cfg.EgressAddress,
+		UDSName:      cfg.EgressUDSName,
+		CertFile:     cfg.EgressTLSCertFile,
+		KeyFile:      cfg.EgressTLSKeyFile,
+		ClientCAFile: cfg.EgressClientCAFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	srv := egress.NewServer(resolver)
+	go func() {
+		glog.Infof("Serving egress proxy on %s", l.Addr().String())
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			glog.Fatalf("failed serving egress proxy: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// newLeaderElectionConfig configures a leader election using a ConfigMap lock in the
+// namespace of the controller, run is called when the leadership is acquired. Losing
+// the leadership is fatal unless stopc is closed.
+func newLeaderElectionConfig(kubecli kubernetes.Interface, stopc <-chan struct{}, run func(stop <-chan struct{})) (*leaderelection.LeaderElectionConfig, error) {
+	id, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving hostname: %v", err)
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubecli.Core().Events(namespace)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: leaderElectionLock})
+
+	lock, err := resourcelock.New(
+		resourcelock.ConfigMapsResourceLock,
+		namespace,
+		leaderElectionLock,
+		kubecli.Core(),
+		resourcelock.ResourceLockConfig{Identity: id, EventRecorder: recorder},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: cfg.LeaseDuration.Duration,
+		RenewDeadline: cfg.RenewDeadline.Duration,
+		RetryPeriod:   cfg.RetryPeriod.Duration,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				glog.Infof("%s acquired the leadership", id)
+				run(stop)
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-stopc:
+					glog.Infof("%s stopped leading, shutting down", id)
+				default:
+					glog.Fatalf("%s lost the leadership", id)
+				}
+			},
+		},
+	}, nil
+}
+
 func main() {
+	defer glog.Flush()
 	if err := cmd().Execute(); err != nil {
 		fmt.Println(err.Error())
 	}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestNewLeaderElectionConfig(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	apiServer := httptest.NewServer(http.NotFoundHandler())
+	defer apiServer.Close()
+	kubecli := kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL})
+
+	cfg.LeaseDuration.Duration = 15 * time.Second
+	cfg.RenewDeadline.Duration = 10 * time.Second
+	cfg.RetryPeriod.Duration = 2 * time.Second
+	started, stopc := make(chan struct{}), make(chan struct{})
+	le, err := newLeaderElectionConfig(kubecli, stopc, func(stop <-chan struct{}) {
+		close(started)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	if id := le.Lock.Identity(); id != hostname {
+		t.Errorf("expected the lock identity to be the hostname %q, got %q", hostname, id)
+	}
+	if desc := le.Lock.Describe(); desc != "allspark/"+leaderElectionLock {
+		t.Errorf("expected a lock in the namespace of the controller, got %q", desc)
+	}
+	if le.LeaseDuration != cfg.LeaseDuration.Duration || le.RenewDeadline != cfg.RenewDeadline.Duration || le.RetryPeriod != cfg.RetryPeriod.Duration {
+		t.Errorf("expected the durations of the config, got %v, %v and %v", le.LeaseDuration, le.RenewDeadline, le.RetryPeriod)
+	}
+
+	le.Callbacks.OnStartedLeading(make(chan struct{}))
+	select {
+	case <-started:
+	default:
+		t.Errorf("expected the workers to run when the leadership is acquired")
+	}
+	// stopping to lead isn't fatal on shutdown
+	close(stopc)
+	le.Callbacks.OnStoppedLeading()
+}
+
+func TestMergeStop(t *testing.T) {
+	for _, closeFirst := range []bool{true, false} {
+		a, b := make(chan struct{}), make(chan struct{})
+		stop := mergeStop(a, b)
+		select {
+		case <-stop:
+			t.Fatalf("expected the merged channel to be open")
+		default:
+		}
+		if closeFirst {
+			close(a)
+		} else {
+			close(b)
+		}
+		select {
+		case <-stop:
+		case <-time.After(5 * time.Second):
+			t.Errorf("expected the merged channel to be closed when any channel is closed")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serveHealth serves the health checks and metrics in plaintext, it's
+// ready once the informer caches used by the ini-server are synced
+func serveHealth(addr string, synced ...cache.InformerSynced) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				http.Error(w, "informer caches aren't synced", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		glog.Infof("Health checks and metrics listening to %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Fatalf("failed serving health checks: %v", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHealth(t *testing.T) {
+	synced := false
+	srv := serveHealth("127.0.0.1:0", func() bool { return true }, func() bool { return synced })
+	defer srv.Shutdown(context.Background())
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("expected healthz to be ok, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected readyz to fail until the caches are synced, got %d", code)
+	}
+	synced = true
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("expected readyz to be ok, got %d", code)
+	}
+	if code := get("/metrics"); code != http.StatusOK {
+		t.Errorf("expected the metrics to be served, got %d", code)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/request"
+)
+
+// status keeps track of the last sync and reload results of frpc
+var status = &syncStatus{adminPort: 7400}
+
+type syncStatus struct {
+	mu sync.RWMutex
+	// resync returns the current interval between syncs, it's read on each
+	// check to detect a stuck sync loop since the config file can change it
+	resync    func() time.Duration
+	adminPort int
+
+	LastSync           *time.Time `json:"lastSync,omitempty"`
+	LastSuccessfulSync *time.Time `json:"lastSuccessfulSync,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	IniHash            string     `json:"iniHash,omitempty"`
+	LastReload         *time.Time `json:"lastReload,omitempty"`
+	LastReloadError    string     `json:"lastReloadError,omitempty"`
+	LastRollback       *time.Time `json:"lastRollback,omitempty"`
+}
+
+// statusResponse is the payload served by the '/status' endpoint
+type statusResponse struct {
+	*syncStatus
+	Proxies     []api.FrpcProxyStatus `json:"proxies"`
+	ProxyStatus string                `json:"proxyStatus,omitempty"`
+}
+
+func (s *syncStatus) synced(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.LastSync = &now
+	if err != nil {
+		s.LastError = err.Error()
+		return
+	}
+	s.LastSuccessfulSync = &now
+	s.LastError = ""
+}
+
+func (s *syncStatus) written(data []byte, adminPort int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := sha256.Sum256(data)
+	s.IniHash = hex.EncodeToString(sum[:])
+	s.adminPort = adminPort
+}
+
+func (s *syncStatus) reloaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.LastReload = &now
+	s.LastReloadError = ""
+}
+
+func (s *syncStatus) reloadFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastReloadError = err.Error()
+}
+
+func (s *syncStatus) rolledBack() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.LastRollback = &now
+}
+
+// isStale returns true if the sync loop didn't run for a few resync intervals
+func (s *syncStatus) isStale() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.LastSync == nil || s.resync == nil {
+		return false
+	}
+	resync := s.resync()
+	if resync == 0 {
+		return false
+	}
+	return time.Since(*s.LastSync) > 3*resync+time.Minute
+}
+
+func (s *syncStatus) proxies() ([]api.FrpcProxyStatus, error) {
+	s.mu.RLock()
+	adminPort := s.adminPort
+	s.mu.RUnlock()
+	addr, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", adminPort))
+	var resp api.FrpcStatus
+	err := request.New(nil, addr).
+		Resource("/api/status").
+		Timeout(requestTimeout).
+		Do().Into(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching frpc status: %v", err)
+	}
+	return resp.Proxies(), nil
+}
+
+// healthz reports if the sync loop is alive
+func (s *syncStatus) healthz(w http.ResponseWriter, r *http.Request) {
+	if s.isStale() {
+		http.Error(w, "sync loop is stale", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports if the ini was synced and all frpc proxies are connected
+func (s *syncStatus) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	synced := s.LastSuccessfulSync != nil
+	s.mu.RUnlock()
+	if !synced {
+		http.Error(w, "ini not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	proxies, err := s.proxies()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	for _, p := range proxies {
+		if !p.IsRunning() {
+			http.Error(w, fmt.Sprintf("proxy %q is %s: %s", p.Name, p.Status, p.Err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *syncStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := statusResponse{syncStatus: s}
+	proxies, err := s.proxies()
+	if err != nil {
+		resp.ProxyStatus = err.Error()
+	}
+	resp.Proxies = proxies
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	if s.LastError != "" || s.LastReloadError != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		glog.Errorf("failed writing status: %v", err)
+	}
+}
+
+// serveStatus starts serving the status endpoints in background
+func serveStatus(addr string, resync func() time.Duration) *http.Server {
+	status.mu.Lock()
+	status.resync = resync
+	status.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", status.healthz)
+	mux.HandleFunc("/readyz", status.readyz)
+	mux.Handle("/status", status)
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		glog.Infof("Serving status on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("failed serving status: %v", err)
+		}
+	}()
+	return srv
+}
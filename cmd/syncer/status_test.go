@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+)
+
+func timeAgo(d time.Duration) *time.Time {
+	t := time.Now().UTC().Add(-d)
+	return &t
+}
+
+func TestIsStale(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		lastSync *time.Time
+		resync   time.Duration
+		expected bool
+	}{
+		{name: "never synced", resync: time.Minute},
+		{name: "resync unknown", lastSync: timeAgo(time.Hour)},
+		{name: "recent sync", lastSync: timeAgo(time.Minute), resync: time.Minute},
+		{name: "stuck sync loop", lastSync: timeAgo(5 * time.Minute), resync: time.Minute, expected: true},
+	} {
+		resync := tc.resync
+		s := &syncStatus{LastSync: tc.lastSync, resync: func() time.Duration { return resync }}
+		if got := s.isStale(); got != tc.expected {
+			t.Errorf("%s: expected stale=%v, got %v", tc.name, tc.expected, got)
+		}
+	}
+	// the resync is read on each check, e.g.: after the config file is reloaded
+	resync := time.Minute
+	s := &syncStatus{LastSync: timeAgo(5 * time.Minute), resync: func() time.Duration { return resync }}
+	if !s.isStale() {
+		t.Fatalf("expected the sync loop to be stuck")
+	}
+	resync = 10 * time.Minute
+	if s.isStale() {
+		t.Errorf("expected the reloaded resync to be used")
+	}
+}
+
+// frpcAdmin serves the given proxies as the frpc admin api '/api/status'
+func frpcAdmin(t *testing.T, proxies ...api.FrpcProxyStatus) (*httptest.Server, int) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/status" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(api.FrpcStatus{HTTP: proxies})
+	}))
+	u, _ := url.Parse(srv.URL)
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("failed parsing port of %q: %v", srv.URL, err)
+	}
+	return srv, port
+}
+
+func TestReadyz(t *testing.T) {
+	running := api.FrpcProxyStatus{Name: "web", Status: "running"}
+	failed := api.FrpcProxyStatus{Name: "api", Status: "start error", Err: "port already used"}
+	for _, tc := range []struct {
+		name     string
+		synced   bool
+		proxies  []api.FrpcProxyStatus
+		down     bool
+		expected int
+	}{
+		{name: "not synced", proxies: []api.FrpcProxyStatus{running}, expected: http.StatusServiceUnavailable},
+		{name: "all proxies running", synced: true, proxies: []api.FrpcProxyStatus{running}, expected: http.StatusOK},
+		{name: "proxy not running", synced: true, proxies: []api.FrpcProxyStatus{running, failed}, expected: http.StatusServiceUnavailable},
+		{name: "admin api down", synced: true, down: true, expected: http.StatusServiceUnavailable},
+	} {
+		srv, port := frpcAdmin(t, tc.proxies...)
+		if tc.down {
+			srv.Close()
+		}
+		s := &syncStatus{adminPort: port}
+		if tc.synced {
+			s.LastSuccessfulSync = timeAgo(time.Second)
+		}
+		rec := httptest.NewRecorder()
+		s.readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != tc.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.expected, rec.Code, rec.Body.String())
+		}
+		srv.Close()
+	}
+}
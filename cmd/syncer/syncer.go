@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/ini.v1"
@@ -16,7 +21,11 @@ import (
 
 	"github.com/sparkcorp/allspark/pkg/api"
 	"github.com/sparkcorp/allspark/pkg/conf"
+	"github.com/sparkcorp/allspark/pkg/controller"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/metrics"
 	"github.com/sparkcorp/allspark/pkg/request"
+	"github.com/sparkcorp/allspark/pkg/signals"
 	"github.com/sparkcorp/allspark/pkg/version"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -28,12 +37,27 @@ const (
 	defaultIngressServer = "http://frpc-ingress-server.$NAMESPACE.svc.cluster.local"
 	defaultIniPath       = "/etc/frpc/config.ini"
 	publicNamespace      = "kube-public"
+	configReloadPeriod   = 10 * time.Second
+	// requestTimeout bounds the requests to the ini-server and the frpc admin api, retries included
+	requestTimeout = 10 * time.Second
+	// tokenReloadPeriod is how often the bearer token file is read again
+	tokenReloadPeriod = time.Minute
+	// frpc is polled for a few minutes after its first start to seed the last known-good ini
+	seedInterval = 5 * time.Second
+	seedAttempts = 60
 )
 
+// watchRetryPolicy is the backoff used to reconnect the watch of the ini-server
+var watchRetryPolicy = request.RetryPolicy{
+	Backoff:    time.Second,
+	MaxBackoff: time.Minute,
+	Jitter:     0.2,
+}
+
 var (
 	showVersionAndExit bool
 	cfg                conf.Config
-	syncType           string
+	configFile         string
 )
 
 type Config struct {
@@ -54,6 +78,15 @@ func cmd() *cobra.Command {
 			}
 			version.Print()
 
+			if configFile != "" {
+				if err := conf.LoadFile(configFile, conf.KindSyncer, &cfg, cmd.Flags()); err != nil {
+					return err
+				}
+			}
+			if err := cfg.Validate(conf.KindSyncer); err != nil {
+				return err
+			}
+
 			kubecli := kubernetes.NewForConfigOrDie(api.NewKubernetesConfig(&cfg))
 			v, err := kubecli.Discovery().ServerVersion()
 			if err != nil {
@@ -62,65 +95,178 @@ func cmd() *cobra.Command {
 			glog.Infof("Running in Kubernetes Cluster version v%v.%v (%v) - git (%v) commit %v - platform %v",
 				v.Major, v.Minor, v.GitVersion, v.GitTreeState, v.GitCommit, v.Platform)
 
-			sleepTime := time.Second * time.Duration(cfg.DefaultIniResync)
-			switch conf.SyncType(syncType) {
+			metrics.RegisterSyncer()
+			stopc := signals.SetupSignalHandler()
+			if configFile != "" {
+				go conf.Watch(configFile, conf.KindSyncer, &cfg, configReloadPeriod, stopc)
+			}
+			var srv *http.Server
+			if cfg.StatusAddress != "" {
+				srv = serveStatus(cfg.StatusAddress, func() time.Duration {
+					return time.Second * time.Duration(cfg.IniResync())
+				})
+			}
+			switch cfg.SyncType {
 			case conf.SyncIngress:
-				iniServerURL, err := discoverIniServer(kubecli)
+				iniServer, err := newIniServer(kubecli)
 				if err != nil {
 					glog.Fatalf("failed discovering ini server: %v", err)
 				}
-				for {
-					glog.Infof("sync started!")
-					err := syncFrpcIngress(iniServerURL, cfg.FRPCIniFile)
-					if err != nil {
-						glog.Warningf(err.Error())
-						glog.Warningf("sync failed")
-					} else {
-						glog.Infof("sync with success!")
-					}
-					glog.Infof("resync after %d second(s)", int(sleepTime.Seconds()))
-					time.Sleep(sleepTime)
-				}
+				checkVersionSkew(iniServer)
+				syncLoop(stopc, watchIniServer(iniServer, stopc), func() error {
+					return syncFrpcIngress(iniServer, cfg.FRPCIniFile)
+				})
 			case conf.SyncKubelet:
-				for {
-					glog.Infof("sync started!")
-
-					err := syncFrpcKubelet(kubecli, cfg.FRPCIniFile)
-					if err != nil {
-						glog.Warningf(err.Error())
-						glog.Warningf("sync failed")
-					} else {
-						glog.Infof("synced with success!")
-					}
-					glog.Infof("resync after %d second(s)", int(sleepTime.Seconds()))
-					time.Sleep(sleepTime)
+				// the version is reported to the ini-server, the kubelet ini isn't served by it
+				iniServer, err := newIniServer(kubecli)
+				if err != nil {
+					glog.Warningf("failed discovering ini server, the version won't be reported: %v", err)
 				}
+				syncLoop(stopc, nil, func() error {
+					if iniServer != nil {
+						checkVersionSkew(iniServer)
+					}
+					return syncFrpcKubelet(kubecli, cfg.FRPCIniFile)
+				})
 			default:
-				glog.Fatalf("Sync type not found %q", syncType)
+				glog.Fatalf("Sync type not found %q", cfg.SyncType)
+			}
+			if srv != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+				defer cancel()
+				if err := srv.Shutdown(ctx); err != nil {
+					glog.Warningf("failed shutting down status server: %v", err)
+				}
 			}
+			glog.Info("Shutdown completed")
 			return nil
 		},
 	}
 	c.Flags().BoolVar(&showVersionAndExit, "version", false, "Print version and exit.")
 	c.Flags().StringVar(&cfg.KubeConfigPath, "kubeconfig", "", "Path to kubeconfig file.")
 	c.Flags().StringVar(&cfg.MasterURL, "master-url", "", "Customize the address of the api server.")
-	c.Flags().StringVar(&syncType, "sync", string(conf.SyncIngress), "Which component to sync, 'Kubelet' or 'Ingress'.")
-	c.Flags().Int64Var(&cfg.DefaultIniResync, "resync", 120, "Path to kubeconfig file.")
+	c.Flags().StringVar(&configFile, "config", "", "Path to a SyncerConfig file, flags set on the command line take precedence.")
+	c.Flags().StringVar((*string)(&cfg.SyncType), "sync", string(conf.SyncIngress), "Which component to sync, 'Kubelet' or 'Ingress'.")
+	c.Flags().Int64Var(&cfg.DefaultIniResync, "resync", 120, "Interval in seconds to resync the frpc ini.")
+	c.Flags().Int32Var(&cfg.FRPCAdminPort, "frpc-admin-port", 7400, "The port of the FRPC admin api.")
 	c.Flags().StringVar(&cfg.FRPCIniFile, "frpc-ini", defaultIniPath, "Path to write frpc ini config.")
 	c.Flags().StringVar(&cfg.FRPCIniServer, "frpc-ini-server", defaultIngressServer, "The server to fetch the FRPC ini rules.")
+	c.Flags().StringVar(&cfg.IniServerCAFile, "ini-server-ca-file", "", "The CA bundle to verify the ini-server, it enables https.")
+	c.Flags().StringVar(&cfg.IniServerCertFile, "ini-server-cert-file", "", "The client certificate presented to the ini-server, it enables https.")
+	c.Flags().StringVar(&cfg.IniServerKeyFile, "ini-server-key-file", "", "The private key of --ini-server-cert-file.")
+	c.Flags().StringVar(&cfg.IniServerTokenFile, "ini-server-token-file", "", "A file with the bearer token sent to the ini-server, e.g.: "+request.ServiceAccountTokenFile)
+	c.Flags().StringVar(&cfg.StatusAddress, "status-address", ":7480", "The address to serve the sync status, empty disables it.")
+	c.Flags().DurationVar(&cfg.ShutdownTimeout.Duration, "shutdown-timeout", 10*time.Second, "The time to wait for in-flight requests when shutting down.")
 	c.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 	return &c
 }
 
-func discoverIniServer(kubecli kubernetes.Interface) (*url.URL, error) {
+// syncLoop calls syncFn periodically or when triggered until stopc is
+// closed, an in-flight sync always completes before returning
+func syncLoop(stopc <-chan struct{}, trigger <-chan struct{}, syncFn func() error) {
+	syncType := string(cfg.SyncType)
+	for {
+		glog.Infof("sync started!")
+		start := time.Now()
+		err := syncFn()
+		metrics.SyncDuration.WithLabelValues(syncType).Observe(time.Since(start).Seconds())
+		status.synced(err)
+		if err != nil {
+			metrics.SyncFailures.WithLabelValues(syncType).Inc()
+			glog.Warningf(err.Error())
+			glog.Warningf("sync failed")
+		} else {
+			glog.Infof("synced with success!")
+		}
+		// the resync interval could be changed by reloading the config file
+		resync := time.Second * time.Duration(cfg.IniResync())
+		glog.Infof("resync after %d second(s)", int(resync.Seconds()))
+		select {
+		case <-stopc:
+			return
+		case <-trigger:
+			glog.Infof("ini-server notified a change")
+		case <-time.After(resync):
+		}
+	}
+}
+
+// iniServer is the client of the ini-server
+type iniServer struct {
+	*request.IniServerClient
+
+	mu sync.Mutex
+	// hash of the last ini fetched, changes already synced are skipped
+	hash string
+}
+
+func (s *iniServer) lastHash() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hash
+}
+
+func (s *iniServer) setLastHash(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hash = hash
+}
+
+// newIniServer discovers the ini-server and configures its client, https is
+// used when the client has a TLS config or the service port is https
+func newIniServer(kubecli kubernetes.Interface) (*iniServer, error) {
+	tlsConfig := request.TLSConfig{
+		CAFile:   cfg.IniServerCAFile,
+		CertFile: cfg.IniServerCertFile,
+		KeyFile:  cfg.IniServerKeyFile,
+	}
+	var client request.HTTPClient
+	if !tlsConfig.IsEmpty() {
+		tlsClient, err := request.NewTLSClient(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		client = tlsClient
+	}
+	var token request.TokenSource
+	if cfg.IniServerTokenFile != "" {
+		token = request.NewFileTokenSource(cfg.IniServerTokenFile, tokenReloadPeriod)
+	}
+	addr, err := discoverIniServer(kubecli, !tlsConfig.IsEmpty())
+	if err != nil {
+		return nil, err
+	}
+	if addr.Scheme != "https" && token != nil {
+		glog.Warningf("sending the bearer token to %s without tls", addr.String())
+	}
+	s := &iniServer{IniServerClient: request.NewIniServerClient(addr, client, token)}
+	vinfo := version.Get()
+	s.SetHeader("User-Agent", version.UserAgent("ini-sync"))
+	s.SetHeader(version.Header, vinfo.Version)
+	s.SetHeader(version.FRPHeader, vinfo.FRPVersion)
+	if cfg.SyncType == conf.SyncKubelet {
+		s.SetHeader(version.NodeHeader, os.Getenv("POD_NODE_NAME"))
+	}
+	// v1 is served by every ini-server, newer versions are used when available
+	if err := s.Negotiate(requestTimeout); err != nil {
+		glog.Warningf("%v, falling back to %s", err, s.Version())
+	}
+	glog.Infof("Using ini-server %s (%s)", addr.String(), s.Version())
+	return s, nil
+}
+
+func discoverIniServer(kubecli kubernetes.Interface, secure bool) (*url.URL, error) {
 	svc, err := kubecli.Core().Services(publicNamespace).Get("ini-server", metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 	proto := "http"
+	if secure {
+		proto = "https"
+	}
 	var port int32
 	for _, p := range svc.Spec.Ports {
-		if p.Port == 443 {
+		if p.Name == "https" || p.Port == 443 {
 			proto = "https"
 		}
 		port = p.Port
@@ -144,10 +290,17 @@ func syncFrpcKubelet(kubecli kubernetes.Interface, iniPath string) error {
 		glog.Infof("external name is empty for service %q", svc.Name)
 	}
 	glog.Infof("Found the road to valhala: %q, searching for the door ...", frpsAddress)
-	// Get the kubelet service name (same as the host) to
+	// Get the kubelet service name (assigned by the controller) to
 	// discover the tenant and retrieve the FRPS port
 	namespace, nodeName := os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NODE_NAME")
-	serviceName := strings.Split(nodeName, ".")[0]
+	node, err := kubecli.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed getting node %q: %v", nodeName, err)
+	}
+	serviceName := node.Annotations[controller.AnnotationKubeletService]
+	if serviceName == "" {
+		return fmt.Errorf("kubelet service of node %q wasn't assigned yet", nodeName)
+	}
 	svc, err = kubecli.Core().Services(namespace).Get(serviceName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed getting kubelet service: %v", err)
@@ -175,12 +328,37 @@ func syncFrpcKubelet(kubecli kubernetes.Interface, iniPath string) error {
 	common := frpcini.Section("common")
 	common.Key("server_addr").SetValue(frpsAddress)
 	common.Key("server_port").SetValue(strconv.Itoa(int(frpsPort)))
+	if token := os.Getenv("FRPS_TOKEN"); token != "" {
+		common.Key("token").SetValue(token)
+	}
+	// the admin api is required for reloading and reporting the proxy status
+	common.Key("admin_addr").SetValue("127.0.0.1")
+	common.Key("admin_port").SetValue(strconv.Itoa(int(cfg.FRPCAdminPort)))
 	kubelet := frpcini.Section(serviceName)
 	kubelet.Key("type").SetValue("https")
 	kubelet.Key("local_ip").SetValue(os.Getenv("POD_HOST_IP"))
 	kubelet.Key("local_port").SetValue("10250")
 	kubelet.Key("custom_domains").SetValue(nodeName)
 
+	// the egress proxy of the API Server dials the kubelet through a tcp tunnel,
+	// the port is allocated by the controller
+	if port := node.Annotations[controller.AnnotationKubeletTunnelPort]; port != "" {
+		tunnel := frpcini.Section(serviceName + "-tcp")
+		tunnel.Key("type").SetValue("tcp")
+		tunnel.Key("local_ip").SetValue(os.Getenv("POD_HOST_IP"))
+		tunnel.Key("local_port").SetValue("10250")
+		tunnel.Key("remote_port").SetValue(port)
+	} else {
+		glog.Infof("node %q doesn't have a kubelet tunnel port yet", nodeName)
+	}
+
+	return applyIni(frpcini, iniPath, int(cfg.FRPCAdminPort))
+}
+
+// applyIni writes the given ini to disk and reloads frpc. When frpc rejects
+// the new config, the last known-good ini is restored and reloaded again.
+// An unreachable admin api is an error unless frpc wasn't started yet.
+func applyIni(frpcini *ini.File, iniPath string, adminPort int) error {
 	isFirstSync := false
 	if _, err := os.Stat(iniPath); os.IsNotExist(err) {
 		isFirstSync = true
@@ -190,53 +368,245 @@ func syncFrpcKubelet(kubecli kubernetes.Interface, iniPath string) error {
 	}
 	glog.Infof("frpc ini %q wrote with success!", iniPath)
 	printSections(frpcini.Sections())
-	if _, err := reloadFrpc(7400); err != nil && !isFirstSync {
-		glog.Warningf("failed reloading frpc config: %v", err)
+	var buf bytes.Buffer
+	if _, err := frpcini.WriteTo(&buf); err == nil {
+		status.written(buf.Bytes(), adminPort)
 	}
-	return nil
+	err := reloadFrpc(adminPort)
+	switch err.(type) {
+	case nil:
+		metrics.Reloads.WithLabelValues("success").Inc()
+		status.reloaded()
+		// the config was accepted by frpc, keep it as the last known-good
+		if err := frpcini.SaveTo(lastGoodPath(iniPath)); err != nil {
+			glog.Warningf("failed saving last known-good ini: %v", err)
+		}
+		glog.Infof("frpc reloaded with success!")
+		return nil
+	case *reloadError:
+		metrics.Reloads.WithLabelValues("rejected").Inc()
+		status.reloadFailed(err)
+		if rerr := rollbackIni(iniPath, adminPort); rerr != nil {
+			return fmt.Errorf("%v, rollback failed: %v", err, rerr)
+		}
+		metrics.Reloads.WithLabelValues("rollback").Inc()
+		status.rolledBack()
+		return fmt.Errorf("%v, rolled back to the last known-good ini", err)
+	}
+	metrics.Reloads.WithLabelValues("error").Inc()
+	seedLastGood(iniPath)
+	// frpc reads the ini from disk when it starts, it's expected
+	// to fail reloading while the admin api isn't available yet
+	if isFirstSync {
+		return nil
+	}
+	return fmt.Errorf("failed reloading frpc config: %v", err)
 }
 
-func reloadFrpc(adminPort int) ([]byte, error) {
+// seeding is set while seedLastGood waits for frpc
+var seeding int32
+
+// seedLastGood keeps the ini frpc started with as the last known-good, the
+// ini is never reloaded when frpc reads it at startup. frpc exits when it
+// refuses its ini, its admin api answering means the ini was accepted.
+func seedLastGood(iniPath string) {
+	if _, err := os.Stat(lastGoodPath(iniPath)); err == nil {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&seeding, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&seeding, 0)
+		for i := 0; i < seedAttempts; i++ {
+			time.Sleep(seedInterval)
+			if _, err := os.Stat(lastGoodPath(iniPath)); err == nil {
+				return
+			}
+			if _, err := status.proxies(); err != nil {
+				continue
+			}
+			data, err := ioutil.ReadFile(iniPath)
+			if err == nil {
+				err = ioutil.WriteFile(lastGoodPath(iniPath), data, 0644)
+			}
+			if err != nil {
+				glog.Warningf("failed saving last known-good ini: %v", err)
+				return
+			}
+			glog.Infof("frpc started, %q kept as the last known-good ini", iniPath)
+			return
+		}
+	}()
+}
+
+// rollbackIni restores the last known-good ini and reloads frpc
+func rollbackIni(iniPath string, adminPort int) error {
+	lastGood, err := ini.Load(lastGoodPath(iniPath))
+	if err != nil {
+		return fmt.Errorf("failed loading last known-good ini: %v", err)
+	}
+	if err := lastGood.SaveTo(iniPath); err != nil {
+		return fmt.Errorf("failed restoring to %q. %v", iniPath, err)
+	}
+	glog.Warningf("frpc ini %q restored to the last known-good config", iniPath)
+	return reloadFrpc(adminPort)
+}
+
+func lastGoodPath(iniPath string) string {
+	return iniPath + ".last-good"
+}
+
+// reloadError indicates that frpc answered the reload, but refused the config
+// with an error code or a non successful status
+type reloadError struct {
+	code    int
+	message string
+}
+
+func (e *reloadError) Error() string {
+	return fmt.Sprintf("frpc rejected the config (code %d): %s", e.code, e.message)
+}
+
+func reloadFrpc(adminPort int) error {
 	addr, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", adminPort))
-	return request.New(nil, addr).
+	var resp api.FrpcResponse
+	err := request.New(nil, addr).
 		Resource("/api/reload").
-		Do().Raw()
+		Timeout(requestTimeout).
+		Do().Into(&resp)
+	if httpErr, ok := err.(*request.HTTPError); ok {
+		return &reloadError{code: httpErr.StatusCode(), message: httpErr.Message()}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Code != 0 {
+		return &reloadError{code: resp.Code, message: resp.Message}
+	}
+	return nil
 }
 
-func syncFrpcIngress(ingressServer *url.URL, iniPath string) error {
+// checkVersionSkew reports the version of ini-sync to the controller and warns
+// when it isn't supported by it, the controller records it as well
+func checkVersionSkew(server *iniServer) {
+	v, err := server.GetVersion(requestTimeout)
+	if err != nil {
+		glog.V(2).Infof("failed fetching the version of the controller: %v", err)
+		return
+	}
+	own := version.Get()
+	glog.V(2).Infof("controller version %s (frp %s), ini-sync version %s (frp %s)", v.Version, v.FRPVersion, own.Version, own.FRPVersion)
+	if !versionInRange(v.SyncerVersionRange, own.Version) {
+		glog.Warningf("ini-sync %s is outside the range %q supported by the controller %s", own.Version, v.SyncerVersionRange, v.Version)
+	}
+	if !versionInRange(v.FRPVersionRange, own.FRPVersion) {
+		glog.Warningf("frpc %s is outside the range %q supported by the controller %s", own.FRPVersion, v.FRPVersionRange, v.Version)
+	}
+}
+
+// versionInRange returns false only when a release is outside the range
+func versionInRange(rawRange, v string) bool {
+	r, err := version.ParseRange(rawRange)
+	if err != nil {
+		return true
+	}
+	semver, err := version.ParseSemver(v)
+	if err != nil {
+		return true
+	}
+	return r.Contains(semver)
+}
+
+// watchIniServer subscribes to the changes of the ini, the sync loop keeps
+// polling when the ini-server doesn't support watching
+func watchIniServer(server *iniServer, stopc <-chan struct{}) <-chan struct{} {
+	trigger := make(chan struct{}, 1)
+	// servers without discovery (v1 fallback) don't advertise it either
+	if !server.HasCapability(api.CapabilityWatch) {
+		glog.Infof("ini-server doesn't support watching, polling every %d second(s)", cfg.IniResync())
+		return trigger
+	}
+	watcher := server.WatchIngressConfig(os.Getenv("POD_NAMESPACE"), os.Getenv("INGRESS_NAME"), "", watchRetryPolicy)
+	go func() {
+		<-stopc
+		watcher.Stop()
+	}()
+	go func() {
+		for event := range watcher.ResultChan() {
+			switch event.Type {
+			case "changed":
+				if event.ID == server.lastHash() {
+					continue
+				}
+				select {
+				case trigger <- struct{}{}:
+				default:
+				}
+			case "error":
+				glog.Warningf("ini-server watch error: %s", string(event.Data))
+			}
+		}
+	}()
+	return trigger
+}
+
+// iniServerError explains why the ini couldn't be fetched, the current ini is kept
+func iniServerError(err error) error {
+	switch {
+	case request.IsUnauthorized(err), request.IsForbidden(err):
+		return fmt.Errorf("ini-server rejected the credentials, check the token and client certificate: %v", err)
+	case request.IsTooManyRequests(err):
+		return fmt.Errorf("ini-server is throttling this client: %v", err)
+	case request.IsTemporary(err):
+		return fmt.Errorf("ini-server is unavailable: %v", err)
+	}
+	switch request.ReasonForError(err) {
+	case httputil.ReasonIngressNotFound:
+		return fmt.Errorf("ingress %s/%s not found on the ini-server: %v",
+			os.Getenv("POD_NAMESPACE"), os.Getenv("INGRESS_NAME"), err)
+	case httputil.ReasonTenantNotFound, httputil.ReasonFetchService, httputil.ReasonPortNotFound:
+		return fmt.Errorf("the tenant of the ingress isn't ready on the ini-server: %v", err)
+	}
+	return fmt.Errorf("failed fetching ini: %v", err)
+}
+
+func syncFrpcIngress(server *iniServer, iniPath string) error {
 	ingressName := os.Getenv("INGRESS_NAME")
 	namespace := os.Getenv("POD_NAMESPACE")
-	resource := fmt.Sprintf("/v1/namespaces/%s/ingress/%s", namespace, ingressName)
 
-	glog.Infof("Requesting %s", ingressServer.String())
-	rawIni, err := request.New(nil, ingressServer).Resource(resource).Do().Raw()
+	glog.Infof("Requesting %s", server.URL().String())
+	config, err := server.GetIngressConfig(namespace, ingressName, server.lastHash(), requestTimeout)
+	if request.IsNotModified(err) {
+		glog.Infof("ini of %v/%v unchanged", namespace, ingressName)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed fetching ini: %v", err)
+		return iniServerError(err)
 	}
+	rawIni := []byte(config.Ini)
 	frpcini, err := ini.Load(rawIni)
 	if err != nil {
 		return fmt.Errorf("failed loading ini: %v", err)
 	}
-	if err := frpcini.SaveTo(iniPath); err != nil {
-		return fmt.Errorf("failed saving to %q. %v", iniPath, err)
-	}
-	glog.Infof("frpc ini %q wrote with success!", iniPath)
 	adminPort, _ := frpcini.Section("common").Key("admin_port").Int()
 	if adminPort == 0 {
-		adminPort = 7400
+		adminPort = int(cfg.FRPCAdminPort)
 	}
-	printSections(frpcini.Sections())
-	data, err := reloadFrpc(adminPort)
-	if err != nil {
-		glog.Warningf("failed reloading frpc config: %v", err)
-		return nil
+	if err := applyIni(frpcini, iniPath, adminPort); err != nil {
+		return err
+	}
+	hash := config.Hash
+	if hash == "" {
+		hash = controller.ConfigHash(rawIni)
 	}
-	glog.Infof("reloaded %v/%v: %v", namespace, ingressName, string(data))
+	server.setLastHash(hash)
+	glog.Infof("synced %v/%v", namespace, ingressName)
 	return nil
 }
 
 func printSections(sections []*ini.Section) {
-	text := "section=%s, type=%s, local_ip=%s, local_port=%s, custom_domains=%s, locations=%s"
+	text := "section=%s, type=%s, local_ip=%s, local_port=%s, custom_domains=%s, subdomain=%s, locations=%s"
 	for _, sec := range sections {
 		if sec.Key("type").String() == "" {
 			continue
@@ -248,12 +618,14 @@ func printSections(sections []*ini.Section) {
 			sec.Key("local_ip").String(),
 			sec.Key("local_port").String(),
 			sec.Key("custom_domains").String(),
+			sec.Key("subdomain").String(),
 			sec.Key("locations").String(),
 		)
 	}
 }
 
 func main() {
+	defer glog.Flush()
 	if err := cmd().Execute(); err != nil {
 		log.Fatalf("Failed starting frpc server: %v", err)
 	}
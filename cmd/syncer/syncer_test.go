@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ini "gopkg.in/ini.v1"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/request"
+)
+
+func TestSyncLoop(t *testing.T) {
+	cfg.DefaultIniResync = 3600
+	stopc := make(chan struct{})
+	syncs := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		syncLoop(stopc, nil, func() error {
+			syncs++
+			if syncs == 1 {
+				// the in-flight sync completes even when asked to stop
+				close(stopc)
+				time.Sleep(10 * time.Millisecond)
+				return errors.New("failed syncing")
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the sync loop to stop")
+	}
+	if syncs != 1 {
+		t.Errorf("expected the loop to stop after the in-flight sync, got %d syncs", syncs)
+	}
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	if status.LastError != "failed syncing" {
+		t.Errorf("expected the status of the last sync, got %q", status.LastError)
+	}
+}
+
+func TestSyncLoopWaitsResync(t *testing.T) {
+	cfg.DefaultIniResync = 3600
+	stopc := make(chan struct{})
+	syncs := 0
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stopc)
+	}()
+	syncLoop(stopc, nil, func() error {
+		syncs++
+		return nil
+	})
+	if syncs != 1 {
+		t.Errorf("expected a single sync before stopping, got %d", syncs)
+	}
+}
+
+func TestSyncLoopTrigger(t *testing.T) {
+	cfg.DefaultIniResync = 3600
+	stopc := make(chan struct{})
+	trigger := make(chan struct{}, 1)
+	trigger <- struct{}{}
+	syncs := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		syncLoop(stopc, trigger, func() error {
+			syncs++
+			if syncs == 2 {
+				close(stopc)
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the trigger to sync without waiting the resync")
+	}
+	if syncs != 2 {
+		t.Errorf("expected a sync for the trigger, got %d syncs", syncs)
+	}
+}
+
+func TestIniServerError(t *testing.T) {
+	apiError := func(statusCode int, reason string) error {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httputil.HttpError(statusCode, reason).MessageF("failed").Write(w)
+		}))
+		defer srv.Close()
+		addr, _ := url.Parse(srv.URL)
+		_, err := request.New(nil, addr).Retry(request.NoRetry).Do().Raw()
+		return err
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unauthorized", request.NewHTTPError(401, "token expired"), "rejected the credentials"},
+		{"forbidden", request.NewHTTPError(403, "rbac"), "rejected the credentials"},
+		{"throttled", request.NewHTTPError(429, "slow down"), "throttling this client"},
+		{"unavailable", request.NewHTTPError(503, "overloaded"), "ini-server is unavailable"},
+		{"ingress not found", apiError(400, httputil.ReasonIngressNotFound), "not found on the ini-server"},
+		{"tenant not ready", apiError(400, httputil.ReasonPortNotFound), "tenant of the ingress isn't ready"},
+		{"unknown", apiError(500, httputil.ReasonInvalidSection), "failed fetching ini"},
+	}
+	for _, tt := range tests {
+		if err := iniServerError(tt.err); !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q in the error, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+// newFakeFrpc serves the admin api of frpc, configs with a "bad" section are refused
+func newFakeFrpc(t *testing.T, iniPath string, status int) (*httptest.Server, int) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadFile(iniPath)
+		if strings.Contains(string(data), "[bad]") {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"code": 1, "msg": "invalid section"}`)
+			return
+		}
+		fmt.Fprint(w, `{"code": 0}`)
+	}))
+	addr, _ := url.Parse(srv.URL)
+	port, err := strconv.Atoi(addr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, port
+}
+
+func TestApplyIni(t *testing.T) {
+	for _, rejectStatus := range []int{http.StatusOK, http.StatusInternalServerError} {
+		dir, err := ioutil.TempDir("", "allspark-syncer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		iniPath := filepath.Join(dir, "frpc.ini")
+		srv, port := newFakeFrpc(t, iniPath, rejectStatus)
+		defer srv.Close()
+
+		good, _ := ini.Load([]byte("[common]\nserver_port = 7000\n"))
+		if err := applyIni(good, iniPath, port); err != nil {
+			t.Fatalf("status %d: unexpected error: %v", rejectStatus, err)
+		}
+		if _, err := os.Stat(lastGoodPath(iniPath)); err != nil {
+			t.Fatalf("status %d: expected the accepted ini to be kept as the last known-good: %v", rejectStatus, err)
+		}
+
+		bad, _ := ini.Load([]byte("[common]\nserver_port = 7000\n[bad]\n"))
+		err = applyIni(bad, iniPath, port)
+		if err == nil || !strings.Contains(err.Error(), "rolled back") {
+			t.Errorf("status %d: expected the refused ini to be rolled back, got %v", rejectStatus, err)
+		}
+		data, _ := ioutil.ReadFile(iniPath)
+		if strings.Contains(string(data), "[bad]") {
+			t.Errorf("status %d: expected the last known-good ini to be restored, got %q", rejectStatus, data)
+		}
+	}
+}
+
+func TestApplyIniUnreachableFrpc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-syncer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	iniPath := filepath.Join(dir, "frpc.ini")
+	// nothing listens on the admin port of a closed server
+	srv, port := newFakeFrpc(t, iniPath, http.StatusOK)
+	srv.Close()
+
+	frpcini, _ := ini.Load([]byte("[common]\nserver_port = 7000\n"))
+	if err := applyIni(frpcini, iniPath, port); err != nil {
+		t.Errorf("expected no error before frpc starts, got %v", err)
+	}
+	if err := applyIni(frpcini, iniPath, port); err == nil {
+		t.Errorf("expected an error when the admin api of a started frpc is unreachable")
+	}
+}
+
+// TestSyncFrpcIngressFailedApply checks the hash of an ini is only kept once
+// frpc reloads it, a failed apply must not turn the next polls into 304s
+func TestSyncFrpcIngressFailedApply(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "office")
+	os.Setenv("INGRESS_NAME", "web")
+	defer os.Unsetenv("POD_NAMESPACE")
+	defer os.Unsetenv("INGRESS_NAME")
+	dir, err := ioutil.TempDir("", "allspark-syncer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	iniPath := filepath.Join(dir, "frpc.ini")
+	// frpc is running already
+	if err := ioutil.WriteFile(iniPath, []byte("[common]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the admin api drops the connections while frpc is unreachable
+	var reachable int32
+	frpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&reachable) == 0 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, `{"code": 0}`)
+	}))
+	defer frpc.Close()
+	adminPort := frpc.Listener.Addr().(*net.TCPAddr).Port
+
+	const hash = "0123456789abcdef"
+	var conditional []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == fmt.Sprintf("%q", hash) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("W/%q", hash))
+		fmt.Fprintf(w, "[common]\nserver_port = 7000\nadmin_port = %d\n", adminPort)
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+	server := &iniServer{IniServerClient: request.NewIniServerClient(addr, nil, nil)}
+
+	for i := 0; i < 2; i++ {
+		if err := syncFrpcIngress(server, iniPath); err == nil {
+			t.Fatalf("sync %d: expected an error while frpc is unreachable", i)
+		}
+	}
+	atomic.StoreInt32(&reachable, 1)
+	if err := syncFrpcIngress(server, iniPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := syncFrpcIngress(server, iniPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"", "", "", fmt.Sprintf("%q", hash)}
+	if !reflect.DeepEqual(conditional, want) {
+		t.Errorf("expected the ini to be fetched until frpc reloads it, got If-None-Match %q", conditional)
+	}
+}
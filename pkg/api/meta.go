@@ -62,10 +62,13 @@ func NewFRPSConfig(meta metav1.ObjectMeta, bindPort, httpPort, httpsPort int32)
 	}
 }
 
-func NewPortBucket(lister corelisters.ServiceNamespaceLister) *PortBucket {
+// NewPortBucket creates a bucket allocating ports between min and max
+func NewPortBucket(lister corelisters.ServiceNamespaceLister, min, max int32) *PortBucket {
 	b := &PortBucket{
 		serviceLister: lister,
 		store:         make(map[string]map[int32]bool),
+		min:           min,
+		max:           max,
 	}
 	return b
 }
@@ -95,7 +98,7 @@ func (b *PortBucket) Reload(ip string) error {
 // Pop returns an allocable port, 0 indicates that there's no more ports to return
 func (b *PortBucket) Pop(ip string) int32 {
 	for port, free := range b.store[ip] {
-		if port >= 20000 && port <= 21000 && free {
+		if port >= b.min && port <= b.max && free {
 			b.store[ip][port] = false
 			return port
 		}
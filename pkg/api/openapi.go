@@ -0,0 +1,330 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/version"
+)
+
+// Operations of the ini-server api. The routes of the server, the paths used by
+// the client and the OpenAPI document served on '/openapi.json' are built from
+// IniServerOperations, a route is changed by changing its operation.
+const (
+	OpGetAPIVersions     = "getAPIVersions"
+	OpGetOpenAPI         = "getOpenAPI"
+	OpGetVersion         = "getVersion"
+	OpGetIngressIniV1    = "getIngressIniV1"
+	OpWatchIngressV1     = "watchIngressV1"
+	OpGetIngressConfig   = "getIngressConfig"
+	OpWatchIngressConfig = "watchIngressConfig"
+)
+
+// Operation describes a route of the ini-server api
+type Operation struct {
+	ID      string
+	Method  string
+	Path    string
+	Summary string
+	// Parameters are keys of iniServerParameters
+	Parameters []string
+	// Produces overrides the media types of the document, application/json
+	Produces []string
+	// Result is the type of the body of a successful answer, nil is a string
+	Result interface{}
+	// ETag answers 304 Not Modified to a matching If-None-Match
+	ETag bool
+	// Stream answers server-sent events
+	Stream bool
+	// Errors answers an httputil.ApiError when the request fails
+	Errors bool
+}
+
+// Expand returns the path of the operation, the path parameters
+// are given as name and value pairs
+func (o Operation) Expand(params ...string) string {
+	return strings.NewReplacer(params...).Replace(o.Path)
+}
+
+// IniServerOperations are all operations served by the ini-server
+var IniServerOperations = []Operation{
+	{
+		ID:      OpGetAPIVersions,
+		Method:  "GET",
+		Path:    "/apis",
+		Summary: "Lists the versions and capabilities served, servers without it only serve v1.",
+		Result:  APIVersions{},
+	},
+	{
+		ID:      OpGetOpenAPI,
+		Method:  "GET",
+		Path:    "/openapi.json",
+		Summary: "Returns this document.",
+	},
+	{
+		ID:         OpGetVersion,
+		Method:     "GET",
+		Path:       "/version",
+		Summary:    "Returns the version of the controller and the versions it supports, kubelet ini-syncs report their version with it.",
+		Parameters: []string{"clientVersion", "frpVersion", "node"},
+		Result:     ServerVersion{},
+	},
+	{
+		ID:         OpGetIngressIniV1,
+		Method:     "GET",
+		Path:       "/v1/namespaces/{namespace}/ingress/{name}",
+		Summary:    "Returns the frpc ini of an ingress.",
+		Parameters: []string{"namespace", "name", "clientVersion", "frpVersion", "ifNoneMatch"},
+		Produces:   []string{"text/plain", "application/json"},
+		ETag:       true,
+		Errors:     true,
+	},
+	{
+		ID:         OpWatchIngressV1,
+		Method:     "GET",
+		Path:       "/v1/namespaces/{namespace}/ingress/{name}/watch",
+		Summary:    "Streams an event each time the ini of an ingress changes.",
+		Parameters: []string{"namespace", "name", "clientVersion", "frpVersion", "lastEventID"},
+		Stream:     true,
+		Errors:     true,
+	},
+	{
+		ID:         OpGetIngressConfig,
+		Method:     "GET",
+		Path:       "/v2/namespaces/{namespace}/ingresses/{name}",
+		Summary:    "Returns the frpc config of an ingress.",
+		Parameters: []string{"namespace", "name", "clientVersion", "frpVersion", "ifNoneMatch"},
+		Result:     IngressConfig{},
+		ETag:       true,
+		Errors:     true,
+	},
+	{
+		ID:         OpWatchIngressConfig,
+		Method:     "GET",
+		Path:       "/v2/namespaces/{namespace}/ingresses/{name}/watch",
+		Summary:    "Streams an event each time the config of an ingress changes.",
+		Parameters: []string{"namespace", "name", "clientVersion", "frpVersion", "lastEventID"},
+		Stream:     true,
+		Errors:     true,
+	},
+}
+
+// IniServerOperation returns the operation with the given id, it
+// panics on unknown ids since they're constants of this package
+func IniServerOperation(id string) Operation {
+	for _, op := range IniServerOperations {
+		if op.ID == id {
+			return op
+		}
+	}
+	panic(fmt.Sprintf("unknown ini-server operation %q", id))
+}
+
+var iniServerParameters = map[string]map[string]interface{}{
+	"namespace":     {"name": "namespace", "in": "path", "required": true, "type": "string"},
+	"name":          {"name": "name", "in": "path", "required": true, "type": "string", "description": "The name of the ingress"},
+	"ifNoneMatch":   {"name": "If-None-Match", "in": "header", "type": "string", "description": "The ETag of the config the client has"},
+	"clientVersion": {"name": version.Header, "in": "header", "type": "string", "description": "The version of the ini-sync, it's sent with every request"},
+	"frpVersion":    {"name": version.FRPHeader, "in": "header", "type": "string", "description": "The version of frpc bundled with the ini-sync"},
+	"node":          {"name": version.NodeHeader, "in": "header", "type": "string", "description": "The node of a kubelet ini-sync"},
+	"lastEventID":   {"name": "Last-Event-ID", "in": "header", "type": "string", "description": "The hash of the config the client has, it's only sent again when it differs"},
+}
+
+// OpenAPIDocument renders the swagger 2.0 document of IniServerOperations
+func OpenAPIDocument() ([]byte, error) {
+	definitions := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	for _, op := range IniServerOperations {
+		ok := map[string]interface{}{"description": "OK"}
+		switch {
+		case op.Stream:
+			ok = map[string]interface{}{"$ref": "#/responses/EventStream"}
+		case op.Result != nil:
+			schema, err := schemaOf(definitions, reflect.TypeOf(op.Result))
+			if err != nil {
+				return nil, fmt.Errorf("operation %s: %v", op.ID, err)
+			}
+			ok["schema"] = schema
+		case op.ID != OpGetOpenAPI:
+			ok["schema"] = map[string]interface{}{"type": "string"}
+		}
+		responses := map[string]interface{}{"200": ok}
+		if op.ETag {
+			ok["headers"] = map[string]interface{}{"ETag": map[string]interface{}{
+				"type":        "string",
+				"description": "Weak ETag of the config, W/\"<hash>\"",
+			}}
+			responses["304"] = map[string]interface{}{"description": "The config matches If-None-Match"}
+		}
+		var params []interface{}
+		for _, p := range op.Parameters {
+			params = append(params, map[string]interface{}{"$ref": "#/parameters/" + p})
+		}
+		if op.Errors {
+			responses["default"] = map[string]interface{}{"$ref": "#/responses/ApiError"}
+		}
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Stream {
+			operation["produces"] = []string{"text/event-stream"}
+		} else if len(op.Produces) > 0 {
+			operation["produces"] = op.Produces
+		}
+		paths[op.Path] = map[string]interface{}{strings.ToLower(op.Method): operation}
+	}
+	apiError, err := definition(definitions, reflect.TypeOf(httputil.ApiError{}))
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"swagger": "2.0",
+		"info": map[string]interface{}{
+			"title":       "allspark ini-server",
+			"description": "Renders the frpc config of ingresses exposed through the tenant tunnels.",
+			"version":     IniServerV2,
+		},
+		"consumes":   []string{"application/json"},
+		"produces":   []string{"application/json"},
+		"paths":      paths,
+		"parameters": iniServerParameters,
+		"responses": map[string]interface{}{
+			"ApiError": map[string]interface{}{"description": "The request failed", "schema": apiError},
+			"EventStream": map[string]interface{}{
+				"description": "Server-sent events: 'changed' with the hash of the config as id, 'error' with an ApiError as data",
+				"schema":      map[string]interface{}{"type": "string"},
+			},
+		},
+		"definitions": definitions,
+	}, "", "  ")
+}
+
+// definition adds the schema of a struct to definitions and returns a reference to it
+func definition(definitions map[string]interface{}, t reflect.Type) (map[string]interface{}, error) {
+	ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	if _, ok := definitions[t.Name()]; ok {
+		return ref, nil
+	}
+	// a placeholder stops recursive types
+	definitions[t.Name()] = nil
+	properties := map[string]interface{}{}
+	var required []string
+	if err := addProperties(definitions, t, properties, &required); err != nil {
+		return nil, err
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	definitions[t.Name()] = schema
+	return ref, nil
+}
+
+// addProperties reads the exported fields of a struct through their json tags,
+// the fields of embedded structs are inlined as encoding/json does
+func addProperties(definitions map[string]interface{}, t reflect.Type, properties map[string]interface{}, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && tag[0] == "" {
+			if err := addProperties(definitions, ft, properties, required); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		schema, err := schemaOf(definitions, f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s.%s: %v", t.Name(), f.Name, err)
+		}
+		properties[name] = schema
+		if !hasOption(tag[1:], "omitempty") && f.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaOf returns the schema of a type as encoding/json marshals it,
+// it fails on types which don't have a json representation
+func schemaOf(definitions map[string]interface{}, t reflect.Type) (map[string]interface{}, error) {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	case t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(marshalerType):
+		// the fields don't describe the json of a custom marshaler
+		return nil, fmt.Errorf("type %s marshals itself", t)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(definitions, t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return nil, fmt.Errorf("anonymous struct isn't supported")
+		}
+		return definition(definitions, t)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}, nil
+		}
+		items, err := schemaOf(definitions, t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key %s isn't supported", t.Key())
+		}
+		values, err := schemaOf(definitions, t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	}
+	return nil, fmt.Errorf("type %s isn't supported", t)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	data, err := OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	type schema struct {
+		Type       string            `json:"type"`
+		Properties map[string]schema `json:"properties"`
+		Required   []string          `json:"required"`
+		Ref        string            `json:"$ref"`
+	}
+	var doc struct {
+		Definitions map[string]schema `json:"definitions"`
+		Responses   map[string]struct {
+			Schema schema `json:"schema"`
+		} `json:"responses"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("failed decoding the document: %v", err)
+	}
+	tests := []struct {
+		definition string
+		properties map[string]string
+		required   []string
+	}{
+		{
+			definition: "IngressConfig",
+			properties: map[string]string{
+				"apiVersion": "string", "kind": "string", "namespace": "string",
+				"name": "string", "hash": "string", "ini": "string",
+			},
+			required: []string{"apiVersion", "kind", "namespace", "name", "hash", "ini"},
+		},
+		{
+			// the fields of version.Info are inlined
+			definition: "ServerVersion",
+			properties: map[string]string{
+				"version": "string", "git_commit": "string", "build_date": "string", "go_version": "string",
+				"compiler": "string", "platform": "string", "frp_version": "string",
+				"syncer_version_range": "string", "frp_version_range": "string",
+			},
+			required: []string{"version", "git_commit", "build_date", "go_version", "compiler", "platform"},
+		},
+		{
+			definition: "ApiError",
+			properties: map[string]string{"statusCode": "integer", "message": "string", "reason": "string", "requestId": "string"},
+			required:   []string{"statusCode", "message", "reason"},
+		},
+		{
+			definition: "APIVersions",
+			properties: map[string]string{"versions": "array", "preferredVersion": "string", "capabilities": "array"},
+			required:   []string{"versions", "preferredVersion", "capabilities"},
+		},
+	}
+	for _, tt := range tests {
+		def, ok := doc.Definitions[tt.definition]
+		if !ok {
+			t.Errorf("expected the definition %s", tt.definition)
+			continue
+		}
+		properties := map[string]string{}
+		for name, p := range def.Properties {
+			properties[name] = p.Type
+		}
+		if !reflect.DeepEqual(properties, tt.properties) {
+			t.Errorf("%s: expected the properties %v, got %v", tt.definition, tt.properties, properties)
+		}
+		sort.Strings(def.Required)
+		sort.Strings(tt.required)
+		if !reflect.DeepEqual(def.Required, tt.required) {
+			t.Errorf("%s: expected the required properties %v, got %v", tt.definition, tt.required, def.Required)
+		}
+	}
+	if ref := doc.Responses["ApiError"].Schema.Ref; ref != "#/definitions/ApiError" {
+		t.Errorf("expected the ApiError response to reference its definition, got %q", ref)
+	}
+}
+
+type schemaNode struct {
+	Name     string            `json:"name"`
+	Parent   *schemaNode       `json:"parent,omitempty"`
+	Children []schemaNode      `json:"children"`
+	Labels   map[string]string `json:"labels"`
+	Data     []byte            `json:"data"`
+	Weight   float64           `json:"weight"`
+	Created  time.Time         `json:"created"`
+	Untagged bool
+	Skipped  string `json:"-"`
+	private  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	definitions := map[string]interface{}{}
+	ref, err := schemaOf(definitions, reflect.TypeOf(&schemaNode{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ref["$ref"] != "#/definitions/schemaNode" {
+		t.Errorf("expected a reference to the definition, got %v", ref)
+	}
+	got, _ := json.Marshal(definitions["schemaNode"])
+	want := `{"properties":{` +
+		`"Untagged":{"type":"boolean"},` +
+		`"children":{"items":{"$ref":"#/definitions/schemaNode"},"type":"array"},` +
+		`"created":{"format":"date-time","type":"string"},` +
+		`"data":{"format":"byte","type":"string"},` +
+		`"labels":{"additionalProperties":{"type":"string"},"type":"object"},` +
+		`"name":{"type":"string"},` +
+		`"parent":{"$ref":"#/definitions/schemaNode"},` +
+		`"weight":{"type":"number"}},` +
+		`"required":["name","children","labels","data","weight","created","Untagged"],"type":"object"}`
+	if string(got) != want {
+		t.Errorf("expected the schema\n%s\ngot\n%s", want, got)
+	}
+
+	for _, v := range []interface{}{
+		make(chan int),
+		map[int]string{},
+		struct{ F func() }{},
+		json.RawMessage{},
+	} {
+		if _, err := schemaOf(map[string]interface{}{}, reflect.TypeOf(v)); err == nil {
+			t.Errorf("expected an error for the type %T", v)
+		}
+	}
+}
+
+func TestIniServerOperations(t *testing.T) {
+	pathParam := regexp.MustCompile(`{(\w+)}`)
+	ids, routes := map[string]bool{}, map[string]bool{}
+	for _, op := range IniServerOperations {
+		route := op.Method + " " + op.Path
+		if ids[op.ID] || routes[route] {
+			t.Errorf("%s: duplicated operation %s", op.ID, route)
+		}
+		ids[op.ID], routes[route] = true, true
+		if IniServerOperation(op.ID).Path != op.Path {
+			t.Errorf("%s: expected the operation to be found by its id", op.ID)
+		}
+		for _, p := range op.Parameters {
+			if _, ok := iniServerParameters[p]; !ok {
+				t.Errorf("%s: unknown parameter %q", op.ID, p)
+			}
+		}
+		// every path parameter is documented
+		for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+			p, ok := iniServerParameters[m[1]]
+			if !ok || p["in"] != "path" || !contains(op.Parameters, m[1]) {
+				t.Errorf("%s: the path parameter %q isn't documented", op.ID, m[1])
+			}
+		}
+		if op.Stream && !strings.HasSuffix(op.Path, "/watch") {
+			t.Errorf("%s: expected the stream to be served on a watch path", op.ID)
+		}
+	}
+
+	if got := IniServerOperation(OpGetIngressConfig).Expand("{namespace}", "office", "{name}", "web"); got != "/v2/namespaces/office/ingresses/web" {
+		t.Errorf("unexpected expanded path %q", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected unknown operations to panic")
+		}
+	}()
+	IniServerOperation("unknown")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 package api
 
 import (
+	"sort"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/version"
 	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
@@ -23,6 +27,89 @@ type ServerInfo struct {
 	ProxyTypeCount     interface{} `json:"proxy_type_count"`
 }
 
+// FRPSProxies mirrors the response of the frps dashboard api '/api/proxy/{type}'
+type FRPSProxies struct {
+	Proxies []FRPSProxyInfo `json:"proxies"`
+}
+
+type FRPSProxyInfo struct {
+	Name            string      `json:"name"`
+	Conf            interface{} `json:"conf"`
+	TodayTrafficIn  int64       `json:"today_traffic_in"`
+	TodayTrafficOut int64       `json:"today_traffic_out"`
+	CurConns        int64       `json:"cur_conns"`
+	LastStartTime   string      `json:"last_start_time"`
+	LastCloseTime   string      `json:"last_close_time"`
+	Status          string      `json:"status"`
+}
+
+// TenantStatus summarizes the state of the tunnels of a tenant,
+// it's collected from the dashboard api of the tenant frps
+type TenantStatus struct {
+	// Online indicates if the dashboard of the tenant frps is reachable
+	Online             bool          `json:"online"`
+	Error              string        `json:"error,omitempty"`
+	Version            string        `json:"version,omitempty"`
+	ClientCounts       int64         `json:"clientCounts"`
+	CurrentConnections int64         `json:"currentConnections"`
+	TotalTrafficIn     int64         `json:"totalTrafficIn"`
+	TotalTrafficOut    int64         `json:"totalTrafficOut"`
+	Proxies            []TenantProxy `json:"proxies"`
+	LastUpdate         time.Time     `json:"lastUpdate"`
+}
+
+// Proxy returns the status of a proxy by its name, nil if it doesn't exist
+func (s *TenantStatus) Proxy(name string) *TenantProxy {
+	for i := range s.Proxies {
+		if s.Proxies[i].Name == name {
+			return &s.Proxies[i]
+		}
+	}
+	return nil
+}
+
+// TenantSummary is the status of a tenant published on its namespaces, it
+// leaves out counters and timestamps so it only changes when a tunnel does
+type TenantSummary struct {
+	Online  bool           `json:"online"`
+	Error   string         `json:"error,omitempty"`
+	Version string         `json:"version,omitempty"`
+	Proxies []ProxySummary `json:"proxies"`
+}
+
+type ProxySummary struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// Summary returns the summary of the status, proxies are sorted by name and type
+func (s *TenantStatus) Summary() *TenantSummary {
+	summary := &TenantSummary{Online: s.Online, Error: s.Error, Version: s.Version, Proxies: []ProxySummary{}}
+	for _, p := range s.Proxies {
+		summary.Proxies = append(summary.Proxies, ProxySummary{Name: p.Name, Type: p.Type, Status: p.Status})
+	}
+	sort.Slice(summary.Proxies, func(i, j int) bool {
+		a, b := summary.Proxies[i], summary.Proxies[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Type < b.Type
+	})
+	return summary
+}
+
+type TenantProxy struct {
+	Name               string `json:"name"`
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	CurrentConnections int64  `json:"currentConnections"`
+	TodayTrafficIn     int64  `json:"todayTrafficIn"`
+	TodayTrafficOut    int64  `json:"todayTrafficOut"`
+}
+
+// IsOnline returns true if the frpc of the proxy is connected
+func (p *TenantProxy) IsOnline() bool {
+	return p.Status == "online"
+}
+
 type FprcHTTP struct {
 	Section           string `ini:"-"`
 	Type              string `ini:"type"`
@@ -30,7 +117,8 @@ type FprcHTTP struct {
 	LocalPort         int32  `ini:"local_port"`
 	UseEncryption     bool   `ini:"use_encryption,omitempty"`
 	UseCompression    bool   `ini:"use_compression,omitempty"`
-	CustomDomains     string `ini:"custom_domains"`
+	CustomDomains     string `ini:"custom_domains,omitempty"`
+	Subdomain         string `ini:"subdomain,omitempty"`
 	Locations         string `ini:"locations,omitempty"`
 	HostHeaderRewrite string `ini:"host_header_rewrite,omitempty"`
 }
@@ -50,6 +138,40 @@ type FrpcResponse struct {
 	Message string `json:"msg"`
 }
 
+// FrpcStatus mirrors the response of the frpc admin api '/api/status'
+type FrpcStatus struct {
+	TCP   []FrpcProxyStatus `json:"tcp"`
+	UDP   []FrpcProxyStatus `json:"udp"`
+	HTTP  []FrpcProxyStatus `json:"http"`
+	HTTPS []FrpcProxyStatus `json:"https"`
+	STCP  []FrpcProxyStatus `json:"stcp"`
+	XTCP  []FrpcProxyStatus `json:"xtcp"`
+}
+
+// Proxies returns the status of all proxies regardless of its type
+func (s *FrpcStatus) Proxies() []FrpcProxyStatus {
+	var proxies []FrpcProxyStatus
+	for _, p := range [][]FrpcProxyStatus{s.TCP, s.UDP, s.HTTP, s.HTTPS, s.STCP, s.XTCP} {
+		proxies = append(proxies, p...)
+	}
+	return proxies
+}
+
+type FrpcProxyStatus struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Err        string `json:"err"`
+	LocalAddr  string `json:"local_addr"`
+	Plugin     string `json:"plugin"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// IsRunning returns true if the proxy is connected to the frp server
+func (p *FrpcProxyStatus) IsRunning() bool {
+	return p.Status == "running"
+}
+
 type FRPSCommon struct {
 	Namespace   string `ini:"-"`
 	ServiceName string `ini:"-"`
@@ -58,8 +180,13 @@ type FRPSCommon struct {
 	VhostHTTPPort  int32  `ini:"vhost_http_port"`
 	VhostHTTPSPort int32  `ini:"vhost_https_port"`
 	LogLevel       string `ini:"log_level"`
-	Token          string `ini:"token"`
+	Token          string `ini:"token,omitempty"`
 	MaxPoolCount   int    `ini:"max_pool_count"`
+	DashboardPort  int32  `ini:"dashboard_port,omitempty"`
+	DashboardUser  string `ini:"dashboard_user,omitempty"`
+	DashboardPwd   string `ini:"dashboard_pwd,omitempty"`
+	SubdomainHost  string `ini:"subdomain_host,omitempty"`
+	AllowPorts     string `ini:"allow_ports,omitempty"`
 }
 
 // PortBucket keeps track of allocated ports for a given ip address
@@ -67,4 +194,64 @@ type PortBucket struct {
 	serviceLister corelisters.ServiceNamespaceLister
 	namespace     string
 	store         map[string]map[int32]bool
+	// min and max is the window of ports which could be allocated
+	min, max int32
+}
+
+// Versions of the ini-server api, v1 is kept for older ini-sync binaries
+const (
+	IniServerV1 = "v1"
+	IniServerV2 = "v2"
+
+	// CapabilityWatch streams the changes of an ingress
+	CapabilityWatch = "watch"
+	// CapabilityETag answers unchanged configs with 304 Not Modified
+	CapabilityETag = "etag"
+)
+
+// APIVersions is served on '/apis', clients pick the newest version they
+// support. Servers without it only serve v1.
+type APIVersions struct {
+	Versions         []string `json:"versions"`
+	PreferredVersion string   `json:"preferredVersion"`
+	Capabilities     []string `json:"capabilities"`
+}
+
+// Supports returns true if the server serves the given version
+func (v *APIVersions) Supports(version string) bool {
+	for _, s := range v.Versions {
+		if s == version {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCapability returns true if the server advertises the given capability
+func (v *APIVersions) HasCapability(capability string) bool {
+	for _, c := range v.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// IngressConfig is the frpc config of an ingress served by the v2 api
+type IngressConfig struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	// Hash identifies the ini, it's the ETag of the response and the id of the watch events
+	Hash string `json:"hash"`
+	Ini  string `json:"ini"`
+}
+
+// ServerVersion is served on '/version', it describes the controller
+// and the versions of the components it supports
+type ServerVersion struct {
+	version.Info
+	SyncerVersionRange string `json:"syncer_version_range,omitempty"`
+	FRPVersionRange    string `json:"frp_version_range,omitempty"`
 }
@@ -1,5 +1,11 @@
 package conf
 
+import (
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 type SyncType string
 
 const (
@@ -9,17 +15,140 @@ const (
 	SyncIngress SyncType = "Ingress"
 )
 
+// The kubelet tunnels are bound inside the frps pods, the range is shared
+// by all nodes of a tenant and must be allowed by frpsAllowPorts.
+const (
+	KubeletTunnelPortMin = 10000
+	KubeletTunnelPortMax = 19999
+)
+
+// Config holds the configuration of the controller and ini-sync, it's
+// populated by a config file and flags. Fields which could be reloaded
+// without restarting must be read through its accessors.
 type Config struct {
-	KubeConfigPath   string
-	MasterURL        string
-	PublicMasterURL  string
-	FRPCIniFile      string
-	FRPSAddress      string
-	FRPSPort         int32
-	WatchNamespace   string
-	ContainerImage   string
-	FRPSToken        string
-	FRPCIniServer    string
-	FRPSNodeIP       string
-	DefaultIniResync int64
+	// mu guards the fields which are hot reloaded
+	mu sync.RWMutex
+
+	KubeConfigPath   string          `json:"kubeconfig,omitempty"`
+	MasterURL        string          `json:"masterURL,omitempty"`
+	PublicMasterURL  string          `json:"publicMasterURL,omitempty"`
+	FRPCIniFile      string          `json:"frpcIni,omitempty"`
+	FRPSAddress      string          `json:"frpsAddress,omitempty"`
+	WatchNamespace   string          `json:"watchNamespace,omitempty"`
+	ContainerImage   string          `json:"image,omitempty"`
+	FRPSToken        string          `json:"frpsToken,omitempty"`
+	FRPCIniServer    string          `json:"frpcIniServer,omitempty"`
+	FRPCAdminPort    int32           `json:"frpcAdminPort,omitempty"`
+	FRPSNodeIP       string          `json:"nodeIP,omitempty"`
+	DefaultIniResync int64           `json:"resync,omitempty"`
+	SyncType         SyncType        `json:"sync,omitempty"`
+	StatusAddress    string          `json:"statusAddress,omitempty"`
+	ListenAddress    string          `json:"listenAddress,omitempty"`
+	ShutdownTimeout  metav1.Duration `json:"shutdownTimeout,omitempty"`
+	InformerResync   metav1.Duration `json:"informerResync,omitempty"`
+	LogLevel         int             `json:"logLevel,omitempty"`
+
+	// PortRangeMin and PortRangeMax is the window of ports allocated to frps services
+	PortRangeMin int32 `json:"portRangeMin,omitempty"`
+	PortRangeMax int32 `json:"portRangeMax,omitempty"`
+
+	TenantStatusResync int64 `json:"tenantStatusResync,omitempty"`
+
+	LeaderElect   bool            `json:"leaderElect,omitempty"`
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	RetryPeriod   metav1.Duration `json:"retryPeriod,omitempty"`
+
+	FRPSAllowPorts    string `json:"frpsAllowPorts,omitempty"`
+	TenantBaseDomain  string `json:"tenantBaseDomain,omitempty"`
+	DNSConfigMap      string `json:"dnsConfigMap,omitempty"`
+	FRPSDashboardUser string `json:"frpsDashboardUser,omitempty"`
+
+	// TLS of the ini-server, the certificate is reloaded when its files change
+	TLSCertFile     string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile      string `json:"tlsKeyFile,omitempty"`
+	TLSClientCAFile string `json:"tlsClientCAFile,omitempty"`
+	// HealthAddress serves health checks and metrics in plaintext
+	HealthAddress string `json:"healthAddress,omitempty"`
+
+	// RequestTimeout bounds the requests served by the controller, streams aren't bounded
+	RequestTimeout     metav1.Duration `json:"requestTimeout,omitempty"`
+	CORSAllowedOrigins []string        `json:"corsAllowedOrigins,omitempty"`
+
+	// Limits of the ini-server, a zero rate disables its limiter
+	RateLimitPerClient         float64  `json:"rateLimitPerClient,omitempty"`
+	RateLimitPerClientBurst    int      `json:"rateLimitPerClientBurst,omitempty"`
+	RateLimitPerNamespace      float64  `json:"rateLimitPerNamespace,omitempty"`
+	RateLimitPerNamespaceBurst int      `json:"rateLimitPerNamespaceBurst,omitempty"`
+	MaxRequestBytes            int64    `json:"maxRequestBytes,omitempty"`
+	DenyCIDRs                  []string `json:"denyCIDRs,omitempty"`
+	// ClientIPHeader identifies clients behind a proxy, e.g.: X-Forwarded-For. It's
+	// only read on requests from TrustedProxyCIDRs.
+	ClientIPHeader      string   `json:"clientIPHeader,omitempty"`
+	TrustedProxyCIDRs   []string `json:"trustedProxyCIDRs,omitempty"`
+	MaxWatchesPerClient int      `json:"maxWatchesPerClient,omitempty"`
+
+	// Versions supported by the controller, e.g.: ">=0.20.0 <0.21.0". An empty
+	// syncer range allows the same minor release of the controller, not newer.
+	SyncerVersionRange string `json:"syncerVersionRange,omitempty"`
+	FRPVersionRange    string `json:"frpVersionRange,omitempty"`
+
+	// TLS and authentication of the ini-server client, the token file
+	// is read periodically to pick up rotated ServiceAccount tokens
+	IniServerCAFile    string `json:"iniServerCAFile,omitempty"`
+	IniServerCertFile  string `json:"iniServerCertFile,omitempty"`
+	IniServerKeyFile   string `json:"iniServerKeyFile,omitempty"`
+	IniServerTokenFile string `json:"iniServerTokenFile,omitempty"`
+
+	// The egress proxy is disabled when both addresses are empty
+	EgressAddress      string `json:"egressAddress,omitempty"`
+	EgressUDSName      string `json:"egressUDSName,omitempty"`
+	EgressTLSCertFile  string `json:"egressTLSCertFile,omitempty"`
+	EgressTLSKeyFile   string `json:"egressTLSKeyFile,omitempty"`
+	EgressClientCAFile string `json:"egressClientCAFile,omitempty"`
+}
+
+// Image returns the FRP image used by the controller
+func (c *Config) Image() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ContainerImage
+}
+
+// IniResync returns the interval in seconds to resync the frpc ini
+func (c *Config) IniResync() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DefaultIniResync
+}
+
+// TenantResync returns the interval in seconds to collect the status of tenants
+func (c *Config) TenantResync() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.TenantStatusResync
+}
+
+// Reload copies the fields which are safe to change at runtime, it returns
+// the name of the fields which were changed
+func (c *Config) Reload(other *Config) (changed []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if other.ContainerImage != "" && other.ContainerImage != c.ContainerImage {
+		c.ContainerImage = other.ContainerImage
+		changed = append(changed, "image")
+	}
+	if other.DefaultIniResync != 0 && other.DefaultIniResync != c.DefaultIniResync {
+		c.DefaultIniResync = other.DefaultIniResync
+		changed = append(changed, "resync")
+	}
+	if other.TenantStatusResync != 0 && other.TenantStatusResync != c.TenantStatusResync {
+		c.TenantStatusResync = other.TenantStatusResync
+		changed = append(changed, "tenantStatusResync")
+	}
+	if other.LogLevel != c.LogLevel {
+		c.LogLevel = other.LogLevel
+		changed = append(changed, "logLevel")
+	}
+	return changed
 }
@@ -0,0 +1,277 @@
+package conf
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/version"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// APIVersion is the current version of the config file
+	APIVersion = "allspark.sh/v1alpha1"
+
+	// KindController is the kind of the config file of allspark-controller-manager
+	KindController = "ControllerConfig"
+	// KindSyncer is the kind of the config file of ini-sync
+	KindSyncer = "SyncerConfig"
+)
+
+// file is the versioned representation of a config file
+type file struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	*Config
+}
+
+// decodeFile decodes and validates the version of a config file into cfg
+func decodeFile(data []byte, kind string, cfg *Config) error {
+	f := &file{Config: cfg}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return fmt.Errorf("failed decoding config file: %v", err)
+	}
+	if f.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", f.APIVersion, APIVersion)
+	}
+	if f.Kind != kind {
+		return fmt.Errorf("unsupported kind %q, expected %q", f.Kind, kind)
+	}
+	return nil
+}
+
+// LoadFile populates cfg from a config file of the given kind, flags
+// explicitly set on the command line take precedence over the file.
+func LoadFile(path, kind string, cfg *Config, flags *pflag.FlagSet) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed reading config file: %v", err)
+	}
+	changed := map[string]string{}
+	flags.Visit(func(f *pflag.Flag) {
+		changed[f.Name] = f.Value.String()
+	})
+	if err := decodeFile(data, kind, cfg); err != nil {
+		return err
+	}
+	if _, ok := changed["v"]; !ok {
+		SetLogLevel(cfg.LogLevel)
+	}
+	for name, value := range changed {
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("failed restoring flag %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// SetLogLevel changes the verbosity of the logs
+func SetLogLevel(level int) {
+	if err := flag.Set("v", strconv.Itoa(level)); err != nil {
+		glog.Warningf("failed setting log level: %v", err)
+	}
+}
+
+// Validate checks the config of the given kind, all errors are
+// reported at once
+func (c *Config) Validate(kind string) error {
+	var errs []string
+	invalid := func(field, format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, a...)))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		invalid("shutdownTimeout", "must be greater than zero")
+	}
+	if c.FRPCAdminPort <= 0 || c.FRPCAdminPort > 65535 {
+		invalid("frpcAdminPort", "must be a valid port, got %d", c.FRPCAdminPort)
+	}
+	if c.LogLevel < 0 {
+		invalid("logLevel", "must not be negative")
+	}
+	switch kind {
+	case KindController:
+		if c.FRPSAddress == "" {
+			invalid("frpsAddress", "is required")
+		}
+		if c.FRPSNodeIP == "" {
+			invalid("nodeIP", "is required")
+		}
+		if c.ContainerImage == "" {
+			invalid("image", "is required")
+		}
+		if c.ListenAddress == "" {
+			invalid("listenAddress", "is required")
+		}
+		if c.PortRangeMin <= 0 || c.PortRangeMax > 65535 || c.PortRangeMin >= c.PortRangeMax {
+			invalid("portRangeMin/portRangeMax", "must be a valid range of ports, got %d-%d", c.PortRangeMin, c.PortRangeMax)
+		}
+		if c.TenantStatusResync <= 0 {
+			invalid("tenantStatusResync", "must be greater than zero")
+		}
+		if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+			invalid("tlsCertFile/tlsKeyFile", "must be set together")
+		}
+		if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+			invalid("tlsClientCAFile", "requires tlsCertFile")
+		}
+		if c.HealthAddress != "" && c.HealthAddress == c.ListenAddress {
+			invalid("healthAddress", "must differ from listenAddress")
+		}
+		if c.RequestTimeout.Duration < 0 {
+			invalid("requestTimeout", "must not be negative")
+		}
+		if c.RateLimitPerClient < 0 || c.RateLimitPerClientBurst < 0 {
+			invalid("rateLimitPerClient/rateLimitPerClientBurst", "must not be negative")
+		}
+		if c.RateLimitPerNamespace < 0 || c.RateLimitPerNamespaceBurst < 0 {
+			invalid("rateLimitPerNamespace/rateLimitPerNamespaceBurst", "must not be negative")
+		}
+		if c.MaxRequestBytes < 0 {
+			invalid("maxRequestBytes", "must not be negative")
+		}
+		if _, err := httputil.ParseCIDRs(c.DenyCIDRs); err != nil {
+			invalid("denyCIDRs", "%v", err)
+		}
+		if _, err := httputil.ParseCIDRs(c.TrustedProxyCIDRs); err != nil {
+			invalid("trustedProxyCIDRs", "%v", err)
+		}
+		if c.ClientIPHeader != "" && len(c.TrustedProxyCIDRs) == 0 {
+			invalid("clientIPHeader", "requires trustedProxyCIDRs")
+		}
+		if c.MaxWatchesPerClient < 0 {
+			invalid("maxWatchesPerClient", "must not be negative")
+		}
+		if _, err := version.ParseRange(c.SyncerVersionRange); err != nil {
+			invalid("syncerVersionRange", "%v", err)
+		}
+		if _, err := version.ParseRange(c.FRPVersionRange); err != nil {
+			invalid("frpVersionRange", "%v", err)
+		}
+		if c.InformerResync.Duration <= 0 {
+			invalid("informerResync", "must be greater than zero")
+		}
+		if (c.EgressTLSCertFile == "") != (c.EgressTLSKeyFile == "") {
+			invalid("egressTLSCertFile/egressTLSKeyFile", "must be set together")
+		}
+		if c.EgressClientCAFile != "" && c.EgressTLSCertFile == "" {
+			invalid("egressClientCAFile", "requires egressTLSCertFile")
+		}
+		// the proxy reaches every kubelet, it must never be served on tcp without mutual tls
+		if c.EgressAddress != "" && c.EgressUDSName == "" && (c.EgressTLSCertFile == "" || c.EgressClientCAFile == "") {
+			invalid("egressAddress", "requires egressTLSCertFile, egressTLSKeyFile and egressClientCAFile")
+		}
+		if c.FRPSAllowPorts != "" {
+			if err := allowsPorts(c.FRPSAllowPorts, KubeletTunnelPortMin, KubeletTunnelPortMax); err != nil {
+				invalid("frpsAllowPorts", "%v", err)
+			}
+		}
+		if c.LeaderElect {
+			if c.LeaseDuration.Duration <= c.RenewDeadline.Duration {
+				invalid("leaseDuration", "must be greater than renewDeadline")
+			}
+			if c.RenewDeadline.Duration <= c.RetryPeriod.Duration {
+				invalid("renewDeadline", "must be greater than retryPeriod")
+			}
+			if c.RetryPeriod.Duration <= 0 {
+				invalid("retryPeriod", "must be greater than zero")
+			}
+		}
+	case KindSyncer:
+		if c.SyncType != SyncIngress && c.SyncType != SyncKubelet {
+			invalid("sync", "must be %q or %q, got %q", SyncIngress, SyncKubelet, c.SyncType)
+		}
+		if c.FRPCIniFile == "" {
+			invalid("frpcIni", "is required")
+		}
+		if c.DefaultIniResync <= 0 {
+			invalid("resync", "must be greater than zero")
+		}
+		if (c.IniServerCertFile == "") != (c.IniServerKeyFile == "") {
+			invalid("iniServerCertFile/iniServerKeyFile", "must be set together")
+		}
+	default:
+		return fmt.Errorf("unknown config kind %q", kind)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid %s:\n  %s", kind, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// allowsPorts checks if a list of frps allow_ports, e.g.: 2000-3000,3001,
+// covers every port between min and max
+func allowsPorts(spec string, min, max int) error {
+	allowed := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return fmt.Errorf("invalid port %q", part)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil || to < from {
+			return fmt.Errorf("invalid port range %q", part)
+		}
+		for port := from; port <= to && port <= max; port++ {
+			if port >= min {
+				allowed[port] = true
+			}
+		}
+	}
+	if len(allowed) != max-min+1 {
+		return fmt.Errorf("must allow the kubelet tunnel ports %d-%d", min, max)
+	}
+	return nil
+}
+
+// Watch polls the config file and reloads the fields which are safe to
+// change at runtime, invalid files are ignored until they're fixed.
+func Watch(path, kind string, cfg *Config, period time.Duration, stopc <-chan struct{}) {
+	last, _ := ioutil.ReadFile(path)
+	for {
+		select {
+		case <-stopc:
+			return
+		case <-time.After(period):
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			glog.Warningf("failed reading config file: %v", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		newCfg := &Config{}
+		if err := decodeFile(data, kind, newCfg); err != nil {
+			glog.Warningf("ignoring config file change: %v", err)
+			continue
+		}
+		if newCfg.DefaultIniResync < 0 || newCfg.TenantStatusResync < 0 || newCfg.LogLevel < 0 {
+			glog.Warningf("ignoring config file change: resync intervals and log level must not be negative")
+			continue
+		}
+		changed := cfg.Reload(newCfg)
+		for _, field := range changed {
+			if field == "logLevel" {
+				SetLogLevel(newCfg.LogLevel)
+			}
+		}
+		if len(changed) > 0 {
+			glog.Infof("config file reloaded, changed fields: %s", strings.Join(changed, ", "))
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newControllerConfig() *Config {
+	return &Config{
+		FRPSAddress:     "frps.allspark.sh",
+		FRPSNodeIP:      "10.0.0.1",
+		ContainerImage:  "quay.io/sandromello/allspark:v0.0.1-rc.2",
+		ListenAddress:   ":3500",
+		FRPCAdminPort:   7400,
+		ShutdownTimeout: metav1.Duration{Duration: 30 * time.Second},
+		InformerResync:  metav1.Duration{Duration: time.Minute},
+		PortRangeMin:    20000,
+		PortRangeMax:    30000,
+
+		TenantStatusResync: 30,
+		LeaseDuration:      metav1.Duration{Duration: 15 * time.Second},
+		RenewDeadline:      metav1.Duration{Duration: 10 * time.Second},
+		RetryPeriod:        metav1.Duration{Duration: 2 * time.Second},
+	}
+}
+
+func newSyncerConfig() *Config {
+	return &Config{
+		SyncType:         SyncIngress,
+		FRPCIniFile:      "/etc/frpc/frpc.ini",
+		FRPCAdminPort:    7400,
+		DefaultIniResync: 120,
+		ShutdownTimeout:  metav1.Duration{Duration: 30 * time.Second},
+	}
+}
+
+func TestValidateController(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(c *Config)
+		// wantErr is the invalid field, empty if the config is valid
+		wantErr string
+	}{
+		{name: "defaults", edit: func(c *Config) {}},
+		{name: "missing frps address", edit: func(c *Config) { c.FRPSAddress = "" }, wantErr: "frpsAddress"},
+		{name: "missing image", edit: func(c *Config) { c.ContainerImage = "" }, wantErr: "image"},
+		{name: "no shutdown timeout", edit: func(c *Config) { c.ShutdownTimeout.Duration = 0 }, wantErr: "shutdownTimeout"},
+		{name: "invalid admin port", edit: func(c *Config) { c.FRPCAdminPort = 70000 }, wantErr: "frpcAdminPort"},
+		{name: "inverted port range", edit: func(c *Config) { c.PortRangeMin, c.PortRangeMax = 30000, 20000 }, wantErr: "portRangeMin/portRangeMax"},
+		{name: "tls key without cert", edit: func(c *Config) { c.TLSKeyFile = "tls.key" }, wantErr: "tlsCertFile/tlsKeyFile"},
+		{name: "client ca without tls", edit: func(c *Config) { c.TLSClientCAFile = "ca.crt" }, wantErr: "tlsClientCAFile"},
+		{name: "health on listen address", edit: func(c *Config) { c.HealthAddress = ":3500" }, wantErr: "healthAddress"},
+		{name: "negative request timeout", edit: func(c *Config) { c.RequestTimeout.Duration = -time.Second }, wantErr: "requestTimeout"},
+		{name: "negative rate limit", edit: func(c *Config) { c.RateLimitPerClient = -1 }, wantErr: "rateLimitPerClient/rateLimitPerClientBurst"},
+		{name: "invalid deny network", edit: func(c *Config) { c.DenyCIDRs = []string{"10.0.0.0/33"} }, wantErr: "denyCIDRs"},
+		{name: "invalid trusted proxy", edit: func(c *Config) { c.TrustedProxyCIDRs = []string{"proxy"} }, wantErr: "trustedProxyCIDRs"},
+		{name: "client ip header without trusted proxies", edit: func(c *Config) { c.ClientIPHeader = "X-Forwarded-For" }, wantErr: "clientIPHeader"},
+		{
+			name: "client ip header with trusted proxies",
+			edit: func(c *Config) { c.ClientIPHeader, c.TrustedProxyCIDRs = "X-Forwarded-For", []string{"10.0.0.0/8"} },
+		},
+		{name: "negative watches", edit: func(c *Config) { c.MaxWatchesPerClient = -1 }, wantErr: "maxWatchesPerClient"},
+		{name: "invalid syncer range", edit: func(c *Config) { c.SyncerVersionRange = "~0.1.0" }, wantErr: "syncerVersionRange"},
+		{name: "invalid frp range", edit: func(c *Config) { c.FRPVersionRange = ">=0.20" }, wantErr: "frpVersionRange"},
+		{name: "no informer resync", edit: func(c *Config) { c.InformerResync.Duration = 0 }, wantErr: "informerResync"},
+		{name: "egress over tcp without mtls", edit: func(c *Config) { c.EgressAddress = ":8131" }, wantErr: "egressAddress"},
+		{name: "egress over uds", edit: func(c *Config) { c.EgressAddress, c.EgressUDSName = ":8131", "/etc/srv/egress.sock" }},
+		{name: "allow ports without tunnel ports", edit: func(c *Config) { c.FRPSAllowPorts = "20000-30000" }, wantErr: "frpsAllowPorts"},
+		{name: "allow ports with tunnel ports", edit: func(c *Config) { c.FRPSAllowPorts = "10000-15000,15001-19999,20000-30000" }},
+		{name: "invalid allow ports", edit: func(c *Config) { c.FRPSAllowPorts = "10000-a" }, wantErr: "frpsAllowPorts"},
+		{name: "leader election defaults", edit: func(c *Config) { c.LeaderElect = true }},
+		{
+			name:    "lease shorter than renew deadline",
+			edit:    func(c *Config) { c.LeaderElect, c.LeaseDuration.Duration = true, 5*time.Second },
+			wantErr: "leaseDuration",
+		},
+		{name: "lease checked only when electing", edit: func(c *Config) { c.LeaseDuration.Duration = 0 }},
+	}
+	for _, tt := range tests {
+		c := newControllerConfig()
+		tt.edit(c)
+		checkValidate(t, tt.name, c.Validate(KindController), tt.wantErr)
+	}
+}
+
+func TestValidateSyncer(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults", edit: func(c *Config) {}},
+		{name: "kubelet", edit: func(c *Config) { c.SyncType = SyncKubelet }},
+		{name: "unknown sync type", edit: func(c *Config) { c.SyncType = "Pod" }, wantErr: "sync"},
+		{name: "missing frpc ini", edit: func(c *Config) { c.FRPCIniFile = "" }, wantErr: "frpcIni"},
+		{name: "no resync", edit: func(c *Config) { c.DefaultIniResync = 0 }, wantErr: "resync"},
+		{name: "cert without key", edit: func(c *Config) { c.IniServerCertFile = "tls.crt" }, wantErr: "iniServerCertFile/iniServerKeyFile"},
+		{name: "client cert", edit: func(c *Config) { c.IniServerCertFile, c.IniServerKeyFile = "tls.crt", "tls.key" }},
+		// the fields of the controller aren't required
+		{name: "controller fields", edit: func(c *Config) { c.InformerResync.Duration = 0 }},
+	}
+	for _, tt := range tests {
+		c := newSyncerConfig()
+		tt.edit(c)
+		checkValidate(t, tt.name, c.Validate(KindSyncer), tt.wantErr)
+	}
+}
+
+func TestValidateUnknownKind(t *testing.T) {
+	if err := newControllerConfig().Validate("PodConfig"); err == nil {
+		t.Errorf("Validate(PodConfig) = nil, want an error")
+	}
+}
+
+func TestAllowsPorts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "1-65535"},
+		{spec: "10-20"},
+		{spec: "10-14, 15,16-20"},
+		{spec: "5-12,8-25"},
+		{spec: "10-19", wantErr: true},
+		{spec: "11-20", wantErr: true},
+		{spec: "10-14,16-20", wantErr: true},
+		{spec: "20-10", wantErr: true},
+		{spec: "a-20", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := allowsPorts(tt.spec, 10, 20); (err != nil) != tt.wantErr {
+			t.Errorf("allowsPorts(%q, 10, 20) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func checkValidate(t *testing.T, name string, err error, wantErr string) {
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("%s: Validate() error = %v, want nil", name, err)
+	case wantErr != "" && err == nil:
+		t.Errorf("%s: Validate() = nil, want an error of %s", name, wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), "\n  "+wantErr+": "):
+		t.Errorf("%s: Validate() error = %v, want an error of %s", name, err, wantErr)
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "controller", data: "apiVersion: allspark.sh/v1alpha1\nkind: ControllerConfig\nimage: frp:v1\n"},
+		{name: "unknown version", data: "apiVersion: allspark.sh/v1\nkind: ControllerConfig\n", wantErr: true},
+		{name: "wrong kind", data: "apiVersion: allspark.sh/v1alpha1\nkind: SyncerConfig\n", wantErr: true},
+		{name: "invalid yaml", data: "apiVersion: [", wantErr: true},
+	}
+	for _, tt := range tests {
+		c := &Config{}
+		err := decodeFile([]byte(tt.data), KindController, c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && c.ContainerImage != "frp:v1" {
+			t.Errorf("%s: expected the image to be decoded, got %q", tt.name, c.ContainerImage)
+		}
+	}
+}
+
+func TestLoadFileFlagsTakePrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	data := "apiVersion: allspark.sh/v1alpha1\nkind: ControllerConfig\nimage: frp:v1\nnodeIP: 10.0.0.1\nlogLevel: 2\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&c.ContainerImage, "image", "frp:default", "")
+	flags.StringVar(&c.FRPSNodeIP, "node-ip", "", "")
+	flags.Int("v", 0, "")
+	if err := flags.Parse([]string{"--node-ip=10.0.0.2", "--v=4"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path, KindController, c, flags); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.ContainerImage != "frp:v1" {
+		t.Errorf("expected the image of the file, got %q", c.ContainerImage)
+	}
+	if c.FRPSNodeIP != "10.0.0.2" {
+		t.Errorf("expected the node ip of the flag, got %q", c.FRPSNodeIP)
+	}
+}
+
+func TestReload(t *testing.T) {
+	c := newControllerConfig()
+	changed := c.Reload(&Config{
+		ContainerImage:     "frp:v2",
+		TenantStatusResync: 60,
+		// only the fields safe to change at runtime are reloaded
+		FRPSAddress: "other.allspark.sh",
+	})
+	if want := []string{"image", "tenantStatusResync"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("expected the changed fields %v, got %v", want, changed)
+	}
+	if c.Image() != "frp:v2" || c.TenantResync() != 60 {
+		t.Errorf("expected the reloaded fields, got %q and %d", c.Image(), c.TenantResync())
+	}
+	if c.FRPSAddress != "frps.allspark.sh" {
+		t.Errorf("expected frpsAddress to not be reloaded, got %q", c.FRPSAddress)
+	}
+	if changed := c.Reload(&Config{ContainerImage: "frp:v2"}); len(changed) != 0 {
+		t.Errorf("expected no changes, got %v", changed)
+	}
+}
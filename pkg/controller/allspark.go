@@ -3,8 +3,9 @@ package controller
 import (
 	"fmt"
 	"os"
+	"path"
 	"reflect"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/sparkcorp/allspark/pkg/api"
@@ -13,12 +14,16 @@ import (
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	extensions "k8s.io/api/extensions/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -35,6 +40,11 @@ const (
 	MessageMaxPortsReached = "Max ports allocation reached for IP %q"
 	MessageResourceExists  = "Resource %q already exists and is not managed by Ingress"
 	LabelPrefix            = "allspark.sh"
+
+	// LabelComponent identifies the frpc and frps pods, the pod informers
+	// only cache the pods matching PodSelector
+	LabelComponent = LabelPrefix + "/component"
+	PodSelector    = LabelComponent + " in (frpc,frps)"
 )
 
 type ASController struct {
@@ -49,17 +59,39 @@ type ASController struct {
 	NodeLister    corelister.NodeLister
 	NodeHasSynced cache.InformerSynced
 
-	// Used by IngressToIni Handler
+	// Used by IngressToIni Handler, it only caches the system namespace
 	ServiceLister    corelister.ServiceLister
 	ServiceHasSynced cache.InformerSynced
 
+	// frpc pods on the watched namespaces
+	PodLister    corelister.PodLister
+	PodHasSynced cache.InformerSynced
+
+	// frps pods and secrets on the system namespace
+	SystemPodLister    corelister.PodLister
+	SystemPodHasSynced cache.InformerSynced
+	SecretLister       corelister.SecretLister
+	SecretHasSynced    cache.InformerSynced
+
 	ingQueue  *TaskQueue
 	nsQueue   *TaskQueue
 	nodeQueue *TaskQueue
+	dnsQueue  *TaskQueue
 
 	portBucket *api.PortBucket
 	cfg        *conf.Config
-	// recorder *Recorder
+
+	// tunnelMu guards the allocation of kubelet tunnel ports,
+	// tunnelPorts maps the ports of a tenant to their nodes
+	tunnelMu    sync.Mutex
+	tunnelPorts map[string]map[int]string
+
+	statusMu     sync.RWMutex
+	tenantStatus map[string]*api.TenantStatus
+
+	skew     *versionSkew
+	roll     *imageRoll
+	recorder record.EventRecorder
 }
 
 // TODO: if the controller has a distinct token, recreate all pods
@@ -69,8 +101,11 @@ func NewASController(
 	cli kubernetes.Interface,
 	ingInf extinformer.IngressInformer,
 	nsInf coreinformer.NamespaceInformer,
-	svcInf coreinformer.ServiceInformer,
+	podInf coreinformer.PodInformer,
 	nodeInf coreinformer.NodeInformer,
+	sysSvcInf coreinformer.ServiceInformer,
+	sysPodInf coreinformer.PodInformer,
+	sysSecretInf coreinformer.SecretInformer,
 	cfg *conf.Config,
 ) *ASController {
 	c := &ASController{
@@ -81,28 +116,99 @@ func NewASController(
 		NamespaceHasSynced: nsInf.Informer().HasSynced,
 		NodeLister:         nodeInf.Lister(),
 		NodeHasSynced:      nodeInf.Informer().HasSynced,
-		ServiceLister:      svcInf.Lister(),
-		ServiceHasSynced:   svcInf.Informer().HasSynced,
-		portBucket:         api.NewPortBucket(svcInf.Lister().Services(os.Getenv("POD_NAMESPACE"))),
-
-		cfg: cfg,
+		ServiceLister:      sysSvcInf.Lister(),
+		ServiceHasSynced:   sysSvcInf.Informer().HasSynced,
+		PodLister:          podInf.Lister(),
+		PodHasSynced:       podInf.Informer().HasSynced,
+		SystemPodLister:    sysPodInf.Lister(),
+		SystemPodHasSynced: sysPodInf.Informer().HasSynced,
+		SecretLister:       sysSecretInf.Lister(),
+		SecretHasSynced:    sysSecretInf.Informer().HasSynced,
+		portBucket: api.NewPortBucket(
+			sysSvcInf.Lister().Services(os.Getenv("POD_NAMESPACE")),
+			cfg.PortRangeMin,
+			cfg.PortRangeMax,
+		),
+
+		cfg:  cfg,
+		skew: newVersionSkew(cfg),
+		roll: newImageRoll(),
 	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cli.Core().Events("")})
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "allspark-controller-manager"})
 	c.ingQueue = NewTaskQueue("frpc-operator", c.syncIngress)
 	c.nsQueue = NewTaskQueue("frps-operator", c.syncNamespaces)
 	c.nodeQueue = NewTaskQueue("node-operator", c.syncNodes)
+	c.dnsQueue = NewTaskQueue("dns-operator", c.syncDNS)
 
 	ingInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			if isFrpIngress(obj.(*extensions.Ingress)) {
 				c.ingQueue.Add(obj)
+				c.enqueueDNS()
 			}
 		},
 		UpdateFunc: func(o, n interface{}) {
 			// Resync periodically all resources
 			if isFrpIngress(n.(*extensions.Ingress)) {
 				c.ingQueue.Add(n)
+				c.enqueueDNS()
 			}
 		},
+		DeleteFunc: func(obj interface{}) { c.enqueueDNS() },
+	})
+
+	// kubelet services are resolved by the dns hosts, changes are
+	// reconciled by the node which owns the service
+	sysSvcInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueServiceNode(obj)
+			c.enqueueTenant(obj)
+			c.enqueueDNS()
+		},
+		UpdateFunc: func(o, n interface{}) {
+			c.enqueueServiceNode(n)
+			c.enqueueTenant(n)
+			c.enqueueDNS()
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.enqueueServiceNode(obj)
+			c.enqueueTenant(obj)
+			c.enqueueDNS()
+		},
+	})
+
+	// frps pods are resolved by the dns hosts and recreated when they fail
+	sysPodInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueTenant(obj)
+			c.enqueueDNS()
+		},
+		UpdateFunc: func(o, n interface{}) {
+			if o.(*v1.Pod).ResourceVersion != n.(*v1.Pod).ResourceVersion {
+				c.enqueueTenant(n)
+				c.enqueueDNS()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.enqueueTenant(obj)
+			c.enqueueDNS()
+		},
+	})
+
+	sysSecretInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(o, n interface{}) { c.enqueueTenant(n) },
+		DeleteFunc: func(obj interface{}) { c.enqueueTenant(obj) },
+	})
+
+	podInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(o, n interface{}) {
+			if o.(*v1.Pod).ResourceVersion != n.(*v1.Pod).ResourceVersion {
+				c.enqueueIngress(n)
+			}
+		},
+		DeleteFunc: func(obj interface{}) { c.enqueueIngress(obj) },
 	})
 
 	nsInf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -122,6 +228,7 @@ func NewASController(
 		AddFunc: func(obj interface{}) {
 			if isAllSparkResource(obj.(*v1.Node)) {
 				c.nodeQueue.Add(obj)
+				c.enqueueDNS()
 			}
 		},
 		UpdateFunc: func(o, n interface{}) {
@@ -129,32 +236,92 @@ func NewASController(
 			new := n.(*v1.Node)
 			if old.ResourceVersion != new.ResourceVersion && isAllSparkResource(new) {
 				c.nodeQueue.Add(new)
+				c.enqueueDNS()
 			}
 		},
+		DeleteFunc: func(obj interface{}) { c.enqueueDNS() },
 	})
 	return c
 }
 
+// Run starts the workers until stopCh is closed, it returns once every worker
+// exits. The queues are shut down at once and the items already queued are
+// synced, the caller bounds how long it waits for them.
 func (c *ASController) Run(threadiness int, stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
-	defer c.ingQueue.Shutdown()
-	defer c.nsQueue.Shutdown()
-	defer c.nodeQueue.Shutdown()
+	var workers sync.WaitGroup
+	defer func() {
+		for _, q := range []*TaskQueue{c.ingQueue, c.nsQueue, c.nodeQueue, c.dnsQueue} {
+			q.Shutdown()
+		}
+		workers.Wait()
+	}()
+	start := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
 
-	if !cache.WaitForCacheSync(stopCh, c.IngressHasSynced, c.NamespaceHasSynced, c.NodeHasSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.IngressHasSynced, c.NamespaceHasSynced, c.NodeHasSynced,
+		c.ServiceHasSynced, c.PodHasSynced, c.SystemPodHasSynced, c.SecretHasSynced) {
 		return
 	}
 
 	glog.Infof("Starting allspark controller manager ...")
 	for i := 0; i < threadiness; i++ {
-		go c.ingQueue.run(time.Second, stopCh)
-		go c.nsQueue.run(time.Second, stopCh)
-		go c.nodeQueue.run(time.Second, stopCh)
+		start(func() { c.ingQueue.run(time.Second, stopCh) })
+		start(func() { c.nsQueue.run(time.Second, stopCh) })
+		start(func() { c.nodeQueue.run(time.Second, stopCh) })
 	}
+	// a single worker renders the dns hosts
+	start(func() { c.dnsQueue.run(time.Second, stopCh) })
+	start(func() { c.runTenantPoller(stopCh) })
 	<-stopCh
 	glog.Infof("Shutting down allspark controller manager ...")
 }
 
+// objectFromEvent unwraps the object of a delete event
+func objectFromEvent(obj interface{}) (metav1.Object, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	meta, ok := obj.(metav1.Object)
+	return meta, ok
+}
+
+// enqueueTenant requeues the namespaces of the tenant owning a frps resource
+func (c *ASController) enqueueTenant(obj interface{}) {
+	meta, ok := objectFromEvent(obj)
+	if !ok {
+		return
+	}
+	tenant := meta.GetLabels()["tenant"]
+	if ref := metav1.GetControllerOf(meta); ref == nil || ref.Kind != "Namespace" || tenant == "" {
+		return
+	}
+	namespaces, err := c.NamespaceLister.List(labels.SelectorFromSet(map[string]string{"allspark.sh/tenant": tenant}))
+	if err != nil {
+		glog.Warningf("failed listing namespaces of tenant %q: %v", tenant, err)
+		return
+	}
+	for _, ns := range namespaces {
+		c.nsQueue.Add(ns)
+	}
+}
+
+// enqueueIngress requeues the ingress owning a frpc pod
+func (c *ASController) enqueueIngress(obj interface{}) {
+	meta, ok := objectFromEvent(obj)
+	if !ok {
+		return
+	}
+	if ref := metav1.GetControllerOf(meta); ref != nil && ref.Kind == "Ingress" {
+		c.ingQueue.AddKey(meta.GetNamespace() + "/" + ref.Name)
+	}
+}
+
 func (c *ASController) syncNamespaces(key string) error {
 	ns, err := c.NamespaceLister.Get(key)
 	if err != nil {
@@ -177,7 +344,7 @@ func (c *ASController) syncNamespaces(key string) error {
 
 	// Sync FRPS Service
 	newService := newFRPSService(ns, tenant, c.cfg.FRPSNodeIP, ports[0], ports[1], ports[2])
-	svc, err := c.kubecli.Core().Services(systemNamespace).Get(tenant, metav1.GetOptions{})
+	svc, err := c.ServiceLister.Services(systemNamespace).Get(tenant)
 	if apierrors.IsNotFound(err) {
 		s, err := c.kubecli.Core().Services(systemNamespace).Create(newService)
 		if err != nil {
@@ -193,24 +360,84 @@ func (c *ASController) syncNamespaces(key string) error {
 		// TODO: UPDATE the service, the ports has been changed
 	}
 
+	// Sync FRPS config
+	dashboardPassword, err := c.frpsDashboardPassword(tenant)
+	if err != nil {
+		return err
+	}
+	frpsIni, err := c.renderFRPSConfig(tenant, dashboardPassword)
+	if err != nil {
+		return fmt.Errorf("Failed rendering FRPS config: %v", err)
+	}
+	newSecret := newFRPSSecret(ns, tenant, frpsIni, c.cfg.FRPSDashboardUser, dashboardPassword)
+	secret, err := c.SecretLister.Secrets(systemNamespace).Get(newSecret.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := c.kubecli.Core().Secrets(systemNamespace).Create(newSecret); err != nil {
+			return fmt.Errorf("Creating FRPS secret error: %v", err)
+		}
+		glog.Infof("Created tenant secret %q", newSecret.Name)
+	case err != nil:
+		return fmt.Errorf("Failed retrieving FRPS secret %q: %v", newSecret.Name, err)
+	case !reflect.DeepEqual(secret.Data, newSecret.Data):
+		secret = secret.DeepCopy()
+		secret.Data = newSecret.Data
+		if _, err := c.kubecli.Core().Secrets(systemNamespace).Update(secret); err != nil {
+			return fmt.Errorf("Updating FRPS secret error: %v", err)
+		}
+		glog.Infof("Updated tenant secret %q", newSecret.Name)
+	}
+
 	// Sync Pod FRPS
-	newPod := c.newFRPSPod(ns, tenant)
-	pod, err := c.kubecli.Core().Pods(systemNamespace).Get(tenant, metav1.GetOptions{})
+	newPod := c.newFRPSPod(ns, tenant, ConfigHash(frpsIni))
+	pod, err := c.SystemPodLister.Pods(systemNamespace).Get(tenant)
 	if apierrors.IsNotFound(err) {
 		p, err := c.kubecli.Core().Pods(systemNamespace).Create(newPod)
+		if apierrors.IsAlreadyExists(err) {
+			// pods created before LabelComponent aren't cached by the informer
+			return c.labelPod(systemNamespace, tenant, ns, "frps")
+		}
 		if err != nil {
 			return fmt.Errorf("Creating FRPS pod error: %v", err)
 		}
 		glog.Infof("Created tenant pod %q", p.Name)
+		return nil
 	}
-	if err != nil && !apierrors.IsNotFound(err) {
+	if err != nil {
 		return fmt.Errorf("Failed retrieving FPRS service %q: %v", tenant, err)
 	}
+	// roll the pod when the config or the image changes, it will be recreated on requeue.
+	// An image reload rolls the pods of every tenant, they're replaced one at a time
+	podKey := systemNamespace + "/" + pod.Name
+	configChanged := pod.Annotations[AnnotationConfigHash] != newPod.Annotations[AnnotationConfigHash]
+	if configChanged || imagesChanged(pod, newPod) {
+		if !configChanged && pod.DeletionTimestamp == nil && !c.roll.acquire(podKey, c.nsQueue, key) {
+			glog.V(2).Infof("Tenant pod %q waits for another pod to roll its image", pod.Name)
+			return nil
+		}
+		if pod.DeletionTimestamp == nil {
+			if err := c.kubecli.Core().Pods(systemNamespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("Failed deleting outdated FRPS pod %q: %v", pod.Name, err)
+			}
+			glog.Infof("Rolling tenant pod %q, the FRPS config or image has been changed", pod.Name)
+		}
+		return fmt.Errorf("waiting for the outdated FRPS pod %q to terminate", pod.Name)
+	}
+	// failed pods (e.g. evicted) are never restarted, recreate them
+	if pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+		if pod.DeletionTimestamp == nil {
+			if err := c.kubecli.Core().Pods(systemNamespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("Failed deleting terminated FRPS pod %q: %v", pod.Name, err)
+			}
+			glog.Warningf("Recreating tenant pod %q, it terminated with status %q", pod.Name, pod.Status.Phase)
+		}
+		return fmt.Errorf("waiting for the terminated FRPS pod %q to be deleted", pod.Name)
+	}
 	if pod.Status.Phase != v1.PodRunning {
 		glog.Warningf("The FRPS pod should be running, got status %q", pod.Status.Phase)
+		return nil
 	}
-
-	// TODO: update the pod if the config has a distinct version
+	c.roll.release(podKey)
 	return nil
 }
 
@@ -247,14 +474,19 @@ func (c *ASController) syncIngress(key string) error {
 		return err
 	}
 
-	pod, err := c.kubecli.Core().Pods(namespace).Get(ing.Name, metav1.GetOptions{})
+	pod, err := c.PodLister.Pods(namespace).Get(ing.Name)
 	if err != nil && !apierrors.IsNotFound(err) {
 		glog.Infof("failed getting pods: %v", err)
 		return nil
 	}
 
 	if apierrors.IsNotFound(err) {
-		if _, err := c.kubecli.Core().Pods(namespace).Create(c.newFRPCPod(ing)); err != nil {
+		_, err := c.kubecli.Core().Pods(namespace).Create(c.newFRPCPod(ing))
+		if apierrors.IsAlreadyExists(err) {
+			// pods created before LabelComponent aren't cached by the informer
+			return c.labelPod(namespace, ing.Name, ing, "frpc")
+		}
+		if err != nil {
 			return err
 		}
 		// _, err := c.kubecli.Core().Services(namespace).Create(c.newService(ing))
@@ -269,12 +501,71 @@ func (c *ASController) syncIngress(key string) error {
 	if !metav1.IsControlledBy(pod, ing) {
 		return fmt.Errorf(fmt.Sprintf(MessageResourceExists, ing.Name))
 	}
+	if pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+		if pod.DeletionTimestamp == nil {
+			if err := c.kubecli.Core().Pods(namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("failed deleting terminated frpc pod %q: %v", pod.Name, err)
+			}
+			glog.Warningf("%s - recreating frpc pod, it terminated with status %q", key, pod.Status.Phase)
+		}
+		return fmt.Errorf("waiting for the terminated frpc pod %q to be deleted", pod.Name)
+	}
+	// roll the pod when the image is reloaded, it will be recreated on requeue
+	podKey := namespace + "/" + pod.Name
+	if imagesChanged(pod, c.newFRPCPod(ing)) {
+		if pod.DeletionTimestamp == nil && !c.roll.acquire(podKey, c.ingQueue, key) {
+			glog.V(2).Infof("%s - frpc pod waits for another pod to roll its image", key)
+			return nil
+		}
+		if pod.DeletionTimestamp == nil {
+			if err := c.kubecli.Core().Pods(namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("failed deleting outdated frpc pod %q: %v", pod.Name, err)
+			}
+			glog.Infof("%s - rolling frpc pod, the image has been changed", key)
+		}
+		return fmt.Errorf("waiting for the outdated frpc pod %q to terminate", pod.Name)
+	}
+	if pod.Status.Phase == v1.PodRunning {
+		c.roll.release(podKey)
+	}
 
 	glog.Infof("Synced %s with success", key)
 	return nil
 }
 
-// TODO: sync when a service is deleted or updated
+// imagesChanged returns true when a container of the pod
+// runs an image other than the one of the desired pod
+func imagesChanged(pod, desired *v1.Pod) bool {
+	images := map[string]string{}
+	for _, container := range desired.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	for _, container := range pod.Spec.Containers {
+		if image, ok := images[container.Name]; ok && image != container.Image {
+			return true
+		}
+	}
+	return false
+}
+
+// labelPod adds LabelComponent to a pod controlled by owner, the
+// pod is synced again once the informer caches it
+func (c *ASController) labelPod(namespace, name string, owner metav1.Object, component string) error {
+	pod, err := c.kubecli.Core().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed retrieving pod %s/%s: %v", namespace, name, err)
+	}
+	if !metav1.IsControlledBy(pod, owner) {
+		return fmt.Errorf(MessageResourceExists, name)
+	}
+	payload := fmt.Sprintf(`{"metadata": {"labels": {%q: %q}}}`, LabelComponent, component)
+	if _, err := c.kubecli.Core().Pods(namespace).Patch(name, types.MergePatchType, []byte(payload)); err != nil {
+		return fmt.Errorf("failed labeling pod %s/%s: %v", namespace, name, err)
+	}
+	glog.Infof("Labeled %s pod %s/%s", component, namespace, name)
+	return nil
+}
+
 func (c *ASController) syncNodes(key string) error {
 	node, err := c.NodeLister.Get(key)
 	if err != nil {
@@ -284,29 +575,25 @@ func (c *ASController) syncNodes(key string) error {
 		}
 		return err
 	}
-	podNamespace, tenantName := os.Getenv("POD_NAMESPACE"), node.Labels["allspark.sh/tenant"]
-	// expect a cluster service dns. E.g.: node.svc.cluster.local
-	// the first segment corresponds to a service on the namespace
-	serviceName := strings.Split(node.Name, ".")[0]
-	_, err = c.kubecli.Core().Services(podNamespace).Get(serviceName, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed getting service %q: %v", serviceName, err)
+	tenantName := node.Labels["allspark.sh/tenant"]
+	// the service name is stored on the node, the kubelet syncer reads it from there
+	node, serviceName, err := c.ensureKubeletServiceName(node)
+	if err != nil {
+		return err
 	}
-
-	if apierrors.IsNotFound(err) {
-		kubelet := newKubeletService(serviceName, node)
-		_, err := c.kubecli.Core().Services(podNamespace).Create(kubelet)
-		if err != nil {
-			return fmt.Errorf("failed creating kubelet service: %v", err)
-		}
-		return nil
+	if err := c.deleteStaleKubeletServices(node, serviceName); err != nil {
+		return err
 	}
-	payload := fmt.Sprintf(`{"spec": {"selector": "%s"}}`, tenantName)
-	_, err = c.kubecli.Core().Services(podNamespace).Patch(serviceName, types.MergePatchType, []byte(payload))
+	if err := c.syncKubeletService(node, serviceName); err != nil {
+		return err
+	}
+	// the egress proxy dials the kubelet through a tcp tunnel on the tenant frps
+	node, err = c.ensureKubeletTunnelPort(node, tenantName)
 	if err != nil {
-		return fmt.Errorf("failed patching service: %v", err)
+		return err
 	}
-	return nil
+	// the kubelet proxy is named after the service on the tenant frps
+	return c.syncNodeTunnel(node, tenantName, serviceName)
 }
 
 func newService(ing *extensions.Ingress) *v1.Service {
@@ -336,10 +623,12 @@ func newService(ing *extensions.Ingress) *v1.Service {
 }
 
 func newKubeletService(serviceName string, node *v1.Node) *v1.Service {
+	tenant := node.Labels["allspark.sh/tenant"]
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      serviceName,
 			Namespace: os.Getenv("POD_NAMESPACE"),
+			Labels:    map[string]string{"app": "kubelet", "tenant": tenant},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(node, schema.GroupVersionKind{
 					Group:   v1.SchemeGroupVersion.Group,
@@ -355,7 +644,7 @@ func newKubeletService(serviceName string, node *v1.Node) *v1.Service {
 				Port:       10250,
 				TargetPort: intstr.FromString("https"),
 			}},
-			Selector: map[string]string{"tenant": node.Labels["allspark.sh/tenant"]},
+			Selector: map[string]string{"tenant": tenant},
 		},
 	}
 }
@@ -365,6 +654,7 @@ func newFRPSService(refNS *v1.Namespace, tenant, nodeIP string, frps, http, http
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      tenant,
 			Namespace: os.Getenv("POD_NAMESPACE"),
+			Labels:    map[string]string{"tenant": tenant},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(refNS, schema.GroupVersionKind{
 					Group:   v1.SchemeGroupVersion.Group,
@@ -400,10 +690,10 @@ func newFRPSService(refNS *v1.Namespace, tenant, nodeIP string, frps, http, http
 	}
 }
 
-func (c *ASController) newFRPSPod(refNS *v1.Namespace, tenant string) *v1.Pod {
-	return &v1.Pod{
+func newFRPSSecret(refNS *v1.Namespace, tenant string, frpsIni []byte, dashboardUser, dashboardPassword string) *v1.Secret {
+	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      tenant,
+			Name:      tenant + "-frps",
 			Namespace: os.Getenv("POD_NAMESPACE"),
 			Labels:    map[string]string{"tenant": tenant},
 			OwnerReferences: []metav1.OwnerReference{
@@ -414,16 +704,35 @@ func (c *ASController) newFRPSPod(refNS *v1.Namespace, tenant string) *v1.Pod {
 				}),
 			},
 		},
+		Data: map[string][]byte{
+			frpsIniKey:               frpsIni,
+			frpsDashboardUserKey:     []byte(dashboardUser),
+			frpsDashboardPasswordKey: []byte(dashboardPassword),
+		},
+	}
+}
+
+func (c *ASController) newFRPSPod(refNS *v1.Namespace, tenant, configHash string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        tenant,
+			Namespace:   os.Getenv("POD_NAMESPACE"),
+			Labels:      map[string]string{"tenant": tenant, LabelComponent: "frps"},
+			Annotations: map[string]string{AnnotationConfigHash: configHash},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(refNS, schema.GroupVersionKind{
+					Group:   v1.SchemeGroupVersion.Group,
+					Version: v1.SchemeGroupVersion.Version,
+					Kind:    "Namespace",
+				}),
+			},
+		},
 		Spec: v1.PodSpec{
 			Containers: []v1.Container{
 				{
-					Name:  "frps",
-					Image: c.cfg.ContainerImage,
-					Command: []string{
-						"frps",
-						"--vhost_http_port=80",
-						"--vhost_https_port=443",
-					},
+					Name:    "frps",
+					Image:   c.cfg.Image(),
+					Command: []string{"frps", "-c", path.Join(frpsIniPath, frpsIniKey)},
 					Ports: []v1.ContainerPort{
 						{
 							Name:          "frps",
@@ -440,9 +749,25 @@ func (c *ASController) newFRPSPod(refNS *v1.Namespace, tenant string) *v1.Pod {
 							Protocol:      v1.ProtocolTCP,
 							ContainerPort: 443,
 						},
+						{
+							Name:          "dashboard",
+							Protocol:      v1.ProtocolTCP,
+							ContainerPort: frpsDashboardPort,
+						},
 					},
+					VolumeMounts: []v1.VolumeMount{{
+						Name:      "frps-ini",
+						ReadOnly:  true,
+						MountPath: frpsIniPath,
+					}},
 				},
 			},
+			Volumes: []v1.Volume{{
+				Name: "frps-ini",
+				VolumeSource: v1.VolumeSource{
+					Secret: &v1.SecretVolumeSource{SecretName: tenant + "-frps"},
+				},
+			}},
 		},
 	}
 }
@@ -452,7 +777,7 @@ func (c *ASController) newFRPCPod(ing *extensions.Ingress) *v1.Pod {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ing.Name,
 			Namespace: ing.Namespace,
-			Labels:    map[string]string{"app": ing.Name},
+			Labels:    map[string]string{"app": ing.Name, LabelComponent: "frpc"},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(ing, schema.GroupVersionKind{
 					Group:   extensions.SchemeGroupVersion.Group,
@@ -465,7 +790,7 @@ func (c *ASController) newFRPCPod(ing *extensions.Ingress) *v1.Pod {
 			Containers: []v1.Container{
 				{
 					Name:    "frpc",
-					Image:   c.cfg.ContainerImage,
+					Image:   c.cfg.Image(),
 					Command: []string{"frpc", "-c", "/etc/frpc/frpc.ini"},
 					VolumeMounts: []v1.VolumeMount{{
 						Name:      "frpc-ini",
@@ -475,12 +800,32 @@ func (c *ASController) newFRPCPod(ing *extensions.Ingress) *v1.Pod {
 				},
 				{
 					Name:  "sync",
-					Image: c.cfg.ContainerImage,
+					Image: c.cfg.Image(),
 					Command: []string{
 						"ini-sync",
 						"--frpc-ini", "/etc/frpc/frpc.ini",
 						"--logtostderr",
 					},
+					Ports: []v1.ContainerPort{{
+						Name:          "status",
+						Protocol:      v1.ProtocolTCP,
+						ContainerPort: 7480,
+					}},
+					LivenessProbe: &v1.Probe{
+						Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+							Path: "/healthz",
+							Port: intstr.FromString("status"),
+						}},
+						InitialDelaySeconds: 10,
+						PeriodSeconds:       30,
+					},
+					ReadinessProbe: &v1.Probe{
+						Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+							Path: "/readyz",
+							Port: intstr.FromString("status"),
+						}},
+						PeriodSeconds: 10,
+					},
 					VolumeMounts: []v1.VolumeMount{{
 						Name:      "frpc-ini",
 						MountPath: "/etc/frpc",
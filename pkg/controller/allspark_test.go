@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corelister "k8s.io/client-go/listers/core/v1"
+	extlister "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sparkcorp/allspark/pkg/conf"
+)
+
+func TestEnqueueTenant(t *testing.T) {
+	owner := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme-dev", UID: "uid"}}
+	frpsPod := func(tenant string, ref bool) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: tenant, Namespace: "allspark"}}
+		if tenant != "" {
+			pod.Labels = map[string]string{"tenant": tenant}
+		}
+		if ref {
+			pod.OwnerReferences = []metav1.OwnerReference{
+				*metav1.NewControllerRef(owner, v1.SchemeGroupVersion.WithKind("Namespace")),
+			}
+		}
+		return pod
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want int
+	}{
+		{"frps pod", frpsPod("acme", true), 2},
+		{"deleted frps pod", cache.DeletedFinalStateUnknown{Key: "allspark/acme", Obj: frpsPod("acme", true)}, 2},
+		{"tenant without namespaces", frpsPod("globex", true), 0},
+		{"pod without tenant", frpsPod("", true), 0},
+		{"pod without owner", frpsPod("acme", false), 0},
+		{"unknown object", "allspark/acme", 0},
+	}
+	for _, tt := range tests {
+		ctrl := &ASController{
+			NamespaceLister: corelister.NewNamespaceLister(newIndexer(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme-dev", Labels: map[string]string{"allspark.sh/tenant": "acme"}}},
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme-prod", Labels: map[string]string{"allspark.sh/tenant": "acme"}}},
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			)),
+			nsQueue: NewTaskQueue("namespace", func(string) error { return nil }),
+		}
+		ctrl.enqueueTenant(tt.obj)
+		if got := ctrl.nsQueue.Len(); got != tt.want {
+			t.Errorf("%s: expected %d namespace(s) requeued, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEnqueueIngress(t *testing.T) {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "office", UID: "uid"}}
+	frpcPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "web-frpc",
+		Namespace: "office",
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(ing, extensions.SchemeGroupVersion.WithKind("Ingress")),
+		},
+	}}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want int
+	}{
+		{"frpc pod", frpcPod, 1},
+		{"deleted frpc pod", cache.DeletedFinalStateUnknown{Key: "office/web-frpc", Obj: frpcPod}, 1},
+		{"pod without owner", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "office"}}, 0},
+	}
+	for _, tt := range tests {
+		ctrl := &ASController{ingQueue: NewTaskQueue("ingress", func(string) error { return nil })}
+		ctrl.enqueueIngress(tt.obj)
+		if got := ctrl.ingQueue.Len(); got != tt.want {
+			t.Errorf("%s: expected %d ingress(es) requeued, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestLabelPod(t *testing.T) {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "office", UID: "uid"}}
+	pods := map[string]*v1.Pod{
+		"web": {ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "office",
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(ing, extensions.SchemeGroupVersion.WithKind("Ingress")),
+			},
+		}},
+		"other": {ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "office"}},
+	}
+	var patched []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pod, ok := pods[path.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "PATCH" {
+			data, _ := ioutil.ReadAll(r.Body)
+			patched = append(patched, string(data))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pod)
+	}))
+	defer apiServer.Close()
+	ctrl := &ASController{
+		kubecli: kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL}),
+		cfg:     &conf.Config{ContainerImage: "frp:v1"},
+	}
+
+	if err := ctrl.labelPod("office", "web", ing, "frpc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(patched) != 1 || !strings.Contains(patched[0], `"allspark.sh/component": "frpc"`) {
+		t.Errorf("expected the pod to be labeled with its component, got %v", patched)
+	}
+	if err := ctrl.labelPod("office", "other", ing, "frpc"); err == nil || len(patched) != 1 {
+		t.Errorf("expected pods of other owners to not be labeled, got %v", err)
+	}
+
+	selector, err := labels.Parse(PodSelector)
+	if err != nil {
+		t.Fatalf("failed parsing the pod selector: %v", err)
+	}
+	if !selector.Matches(labels.Set(ctrl.newFRPCPod(ing).Labels)) {
+		t.Errorf("expected the frpc pod to match the selector %q", PodSelector)
+	}
+}
+
+func TestImagesChanged(t *testing.T) {
+	pod := func(images ...string) *v1.Pod {
+		p := &v1.Pod{}
+		for i, image := range images {
+			p.Spec.Containers = append(p.Spec.Containers, v1.Container{Name: fmt.Sprintf("c%d", i), Image: image})
+		}
+		return p
+	}
+	tests := []struct {
+		name         string
+		pod, desired *v1.Pod
+		want         bool
+	}{
+		{"same images", pod("frp:v1", "frp:v1"), pod("frp:v1", "frp:v1"), false},
+		{"image changed", pod("frp:v1", "frp:v1"), pod("frp:v1", "frp:v2"), true},
+		{"container not desired", pod("frp:v1", "sidecar:v1"), pod("frp:v1"), false},
+	}
+	for _, tt := range tests {
+		if got := imagesChanged(tt.pod, tt.desired); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSyncIngressImageRoll(t *testing.T) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "office", Labels: map[string]string{"allspark.sh/tenant": "acme"}}}
+	ctrl := &ASController{cfg: &conf.Config{ContainerImage: "frp:v1"}, roll: newImageRoll()}
+	ingIndexer, podIndexer := newIndexer(), newIndexer()
+	for _, name := range []string{"web-0", "web-1", "web-2"} {
+		ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "office", UID: types.UID(name)}}
+		pod := ctrl.newFRPCPod(ing)
+		pod.Status.Phase = v1.PodRunning
+		ingIndexer.Add(ing)
+		podIndexer.Add(pod)
+	}
+	var deleted []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			deleted = append(deleted, path.Base(r.URL.Path))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusSuccess})
+	}))
+	defer apiServer.Close()
+	ctrl.kubecli = kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL})
+	ctrl.NamespaceLister = corelister.NewNamespaceLister(newIndexer(ns))
+	ctrl.IngressLister = extlister.NewIngressLister(ingIndexer)
+	ctrl.PodLister = corelister.NewPodLister(podIndexer)
+	ctrl.ingQueue = NewTaskQueue("ingress", func(string) error { return nil })
+
+	// reload the image, only the first outdated pod is deleted
+	ctrl.cfg.ContainerImage = "frp:v2"
+	for _, key := range []string{"office/web-0", "office/web-1", "office/web-2"} {
+		err := ctrl.syncIngress(key)
+		if key == "office/web-0" && err == nil {
+			t.Errorf("expected the sync of %s to wait for the outdated pod", key)
+		}
+		if key != "office/web-0" && err != nil {
+			t.Errorf("unexpected error syncing %s: %v", key, err)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "web-0" {
+		t.Fatalf("expected only the pod web-0 to be deleted, got %v", deleted)
+	}
+
+	// the new pod is running, the waiting ingresses are requeued
+	obj, _, _ := ingIndexer.GetByKey("office/web-0")
+	pod := ctrl.newFRPCPod(obj.(*extensions.Ingress))
+	pod.Status.Phase = v1.PodRunning
+	podIndexer.Update(pod)
+	if err := ctrl.syncIngress("office/web-0"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ctrl.ingQueue.Len(); got != 2 {
+		t.Errorf("expected 2 ingresses requeued, got %d", got)
+	}
+	if err := ctrl.syncIngress("office/web-1"); err == nil || len(deleted) != 2 || deleted[1] != "web-1" {
+		t.Errorf("expected the pod web-1 to roll next, got %v: %v", deleted, err)
+	}
+}
+
+func TestImageRollTimeout(t *testing.T) {
+	queue := NewTaskQueue("ingress", func(string) error { return nil })
+	roll := newImageRoll()
+	if !roll.acquire("office/web-0", queue, "office/web-0") {
+		t.Fatal("expected the first pod to roll")
+	}
+	if !roll.acquire("office/web-0", queue, "office/web-0") {
+		t.Error("expected the rolling pod to be acquired again")
+	}
+	if roll.acquire("office/web-1", queue, "office/web-1") {
+		t.Error("expected the second pod to wait")
+	}
+	roll.started = time.Now().Add(-imageRollTimeout)
+	if !roll.acquire("office/web-1", queue, "office/web-1") {
+		t.Error("expected the second pod to roll once the first one timed out")
+	}
+	// releasing a pod that isn't rolling is a no-op
+	roll.release("office/web-0")
+	if queue.Len() != 0 || roll.pod != "office/web-1" {
+		t.Errorf("expected the roll of office/web-1 to continue, got %q", roll.pod)
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// dnsQueueKey is the only key of the dns queue, the hosts
+	// file is always rendered from the whole state
+	dnsQueueKey = "hosts"
+	dnsHostsKey = "hosts"
+)
+
+// enqueueDNS schedules rendering the hosts file, it's a no-op if the
+// dns config map isn't configured
+func (c *ASController) enqueueDNS() {
+	if c.cfg.DNSConfigMap != "" {
+		c.dnsQueue.AddKey(dnsQueueKey)
+	}
+}
+
+// syncDNS renders a hosts file resolving node names to its kubelet service and
+// ingress hosts to the tenant frps pod. It's served by the CoreDNS hosts plugin.
+func (c *ASController) syncDNS(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(c.cfg.DNSConfigMap)
+	if err != nil {
+		return fmt.Errorf("invalid dns config map %q: %v", c.cfg.DNSConfigMap, err)
+	}
+	hosts, err := c.dnsHosts()
+	if err != nil {
+		return err
+	}
+	data := map[string]string{dnsHostsKey: renderHosts(hosts)}
+	cm, err := c.kubecli.Core().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       data,
+		}
+		if _, err := c.kubecli.Core().ConfigMaps(namespace).Create(cm); err != nil {
+			return fmt.Errorf("failed creating dns config map: %v", err)
+		}
+		glog.Infof("Created dns config map %s/%s with %d host(s)", namespace, name, len(hosts))
+	case err != nil:
+		return fmt.Errorf("failed retrieving dns config map: %v", err)
+	case cm.Data[dnsHostsKey] != data[dnsHostsKey]:
+		cm = cm.DeepCopy()
+		cm.Data = data
+		if _, err := c.kubecli.Core().ConfigMaps(namespace).Update(cm); err != nil {
+			return fmt.Errorf("failed updating dns config map: %v", err)
+		}
+		glog.Infof("Updated dns config map %s/%s with %d host(s)", namespace, name, len(hosts))
+	}
+	return nil
+}
+
+// dnsHosts maps each hostname to its address
+func (c *ASController) dnsHosts() (map[string]string, error) {
+	systemNamespace := os.Getenv("POD_NAMESPACE")
+	hosts := map[string]string{}
+	nodes, err := c.NodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed listing nodes: %v", err)
+	}
+	for _, node := range nodes {
+		if !isAllSparkResource(node) {
+			continue
+		}
+		serviceName := node.Annotations[AnnotationKubeletService]
+		if serviceName == "" {
+			continue
+		}
+		svc, err := c.ServiceLister.Services(systemNamespace).Get(serviceName)
+		if err != nil || svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == v1.ClusterIPNone {
+			glog.V(2).Infof("kubelet service of node %q not found, skipping dns", node.Name)
+			continue
+		}
+		hosts[node.Name] = svc.Spec.ClusterIP
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == v1.NodeHostName || addr.Type == v1.NodeExternalDNS {
+				hosts[addr.Address] = svc.Spec.ClusterIP
+			}
+		}
+	}
+
+	ingresses, err := c.IngressLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed listing ingresses: %v", err)
+	}
+	frpsIPs := map[string]string{}
+	for _, ing := range ingresses {
+		if !isFrpIngress(ing) {
+			continue
+		}
+		ns, err := c.NamespaceLister.Get(ing.Namespace)
+		if err != nil || ns.Labels["allspark.sh/tenant"] == "" {
+			continue
+		}
+		tenant := ns.Labels["allspark.sh/tenant"]
+		podIP, ok := frpsIPs[tenant]
+		if !ok {
+			podIP, _ = c.frpsPodIP(tenant)
+			frpsIPs[tenant] = podIP
+		}
+		if podIP == "" {
+			continue
+		}
+		for _, r := range ing.Spec.Rules {
+			if host, _, ok := RuleHost(ing, r, c.SubdomainHost(tenant)); ok {
+				hosts[host] = podIP
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// renderHosts renders a hosts file sorted by hostname
+func renderHosts(hosts map[string]string) string {
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString("# generated by allspark-controller-manager, do not edit\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s\n", hosts[name], name)
+	}
+	return buf.String()
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sparkcorp/allspark/pkg/conf"
+
+	"k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	extlister "k8s.io/client-go/listers/extensions/v1beta1"
+)
+
+func TestRenderHosts(t *testing.T) {
+	hosts := map[string]string{
+		"web2.site-a":          "10.96.0.11",
+		"app.acme.allspark.sh": "10.32.0.5",
+		"web1.site-a":          "10.96.0.10",
+	}
+	expected := "# generated by allspark-controller-manager, do not edit\n" +
+		"10.32.0.5 app.acme.allspark.sh\n" +
+		"10.96.0.10 web1.site-a\n" +
+		"10.96.0.11 web2.site-a\n"
+	if got := renderHosts(hosts); got != expected {
+		t.Errorf("expected the hosts sorted by name:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestDNSHosts(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	tenantLabels := map[string]string{"allspark.sh/tenant": "acme"}
+	kubeletNode := func(name string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      tenantLabels,
+			Annotations: map[string]string{AnnotationKubeletService: KubeletServiceName(name)},
+		}}
+	}
+	node := kubeletNode("web1.site-a")
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: "web1.internal"},
+		{Type: v1.NodeInternalIP, Address: "192.168.0.10"},
+	}
+	kubeletSvc := func(name, clusterIP string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "allspark"},
+			Spec:       v1.ServiceSpec{ClusterIP: clusterIP},
+		}
+	}
+	ingress := func(namespace, host string) *extensions.Ingress {
+		return &extensions.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+			Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{{Host: host}}},
+		}
+	}
+	ctrl := &ASController{
+		// only the frps pod of acme is known
+		SystemPodLister: newFRPSPodLister(),
+		NodeLister: corelister.NewNodeLister(newIndexer(
+			node,
+			// the kubelet service of web2 doesn't have an ip
+			kubeletNode("web2.site-a"),
+			// web3 wasn't mapped to a kubelet service yet
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web3.site-a", Labels: tenantLabels}},
+			// not managed by allspark
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}},
+		)),
+		ServiceLister: corelister.NewServiceLister(newIndexer(
+			kubeletSvc(KubeletServiceName("web1.site-a"), "10.96.0.10"),
+			kubeletSvc(KubeletServiceName("web2.site-a"), v1.ClusterIPNone),
+			kubeletSvc("web3", "10.96.0.12"),
+			kubeletSvc("master", "10.96.0.1"),
+		)),
+		NamespaceLister: corelister.NewNamespaceLister(newIndexer(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "office", Labels: tenantLabels}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		)),
+		IngressLister: extlister.NewIngressLister(newIndexer(
+			ingress("office", "app.example.com"),
+			ingress("office-noname", ""),
+			ingress("default", "www.example.com"),
+		)),
+		cfg: &conf.Config{TenantBaseDomain: "allspark.sh"},
+	}
+	hosts, err := ctrl.dnsHosts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"web1.site-a":     "10.96.0.10",
+		"web1.internal":   "10.96.0.10",
+		"app.example.com": "10.32.0.5",
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected the hosts %v, got %v", expected, hosts)
+	}
+}
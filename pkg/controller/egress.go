@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/conf"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AnnotationKubeletTunnelPort is the port of the tcp tunnel of the kubelet on the tenant frps
+	AnnotationKubeletTunnelPort = LabelPrefix + "/kubelet-tunnel-port"
+
+	defaultKubeletPort = 10250
+)
+
+// kubeletTunnelPort returns the tunnel port annotated on a node
+func kubeletTunnelPort(node *v1.Node) (int, bool) {
+	port, err := strconv.Atoi(node.Annotations[AnnotationKubeletTunnelPort])
+	if err != nil || port < conf.KubeletTunnelPortMin || port > conf.KubeletTunnelPortMax {
+		return 0, false
+	}
+	return port, true
+}
+
+// ensureKubeletTunnelPort allocates the lowest port not used by the
+// nodes of the tenant and annotates the node with it. The lister may not
+// have seen a port allocated a moment ago, allocations are reserved in
+// memory until the node is gone.
+func (c *ASController) ensureKubeletTunnelPort(node *v1.Node, tenant string) (*v1.Node, error) {
+	if _, ok := kubeletTunnelPort(node); ok {
+		return node, nil
+	}
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	nodes, err := c.NodeLister.List(labels.SelectorFromSet(map[string]string{"allspark.sh/tenant": tenant}))
+	if err != nil {
+		return nil, fmt.Errorf("failed listing nodes of tenant %q: %v", tenant, err)
+	}
+	used := map[int]bool{}
+	exists := map[string]bool{}
+	for _, n := range nodes {
+		exists[n.Name] = true
+		if port, ok := kubeletTunnelPort(n); ok && n.Name != node.Name {
+			used[port] = true
+		}
+	}
+	if c.tunnelPorts == nil {
+		c.tunnelPorts = map[string]map[int]string{}
+	}
+	reserved := c.tunnelPorts[tenant]
+	if reserved == nil {
+		reserved = map[int]string{}
+		c.tunnelPorts[tenant] = reserved
+	}
+	for port, name := range reserved {
+		switch {
+		case !exists[name]:
+			delete(reserved, port)
+		case name != node.Name:
+			used[port] = true
+		}
+	}
+	for port := conf.KubeletTunnelPortMin; port <= conf.KubeletTunnelPortMax; port++ {
+		if used[port] {
+			continue
+		}
+		payload := fmt.Sprintf(`{"metadata": {"annotations": {%q: "%d"}}}`, AnnotationKubeletTunnelPort, port)
+		n, err := c.kubecli.Core().Nodes().Patch(node.Name, types.MergePatchType, []byte(payload))
+		if err != nil {
+			return nil, fmt.Errorf("failed annotating tunnel port of node %q: %v", node.Name, err)
+		}
+		reserved[port] = node.Name
+		glog.Infof("allocated kubelet tunnel port %d to node %q", port, node.Name)
+		return n, nil
+	}
+	return nil, fmt.Errorf("no kubelet tunnel ports available for tenant %q", tenant)
+}
+
+// KubeletTunnelAddress resolves the address of the kubelet tunnel on the
+// tenant frps, the host is the node name or any of its addresses.
+func (c *ASController) KubeletTunnelAddress(hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %v", hostport, err)
+	}
+	node, err := c.nodeByAddress(host)
+	if err != nil {
+		return "", err
+	}
+	kubeletPort := int(node.Status.DaemonEndpoints.KubeletEndpoint.Port)
+	if kubeletPort == 0 {
+		kubeletPort = defaultKubeletPort
+	}
+	if port != strconv.Itoa(kubeletPort) {
+		return "", fmt.Errorf("port %s of node %q isn't the kubelet port", port, node.Name)
+	}
+	tenant := node.Labels["allspark.sh/tenant"]
+	if tenant == "" {
+		return "", fmt.Errorf("node %q doesn't belong to a tenant", node.Name)
+	}
+	tunnelPort, ok := kubeletTunnelPort(node)
+	if !ok {
+		return "", fmt.Errorf("node %q doesn't have a kubelet tunnel port yet", node.Name)
+	}
+	podIP, err := c.frpsPodIP(tenant)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(podIP, strconv.Itoa(tunnelPort)), nil
+}
+
+// nodeByAddress finds an allspark node by its name or any of its addresses
+func (c *ASController) nodeByAddress(host string) (*v1.Node, error) {
+	if node, err := c.NodeLister.Get(host); err == nil && isAllSparkResource(node) {
+		return node, nil
+	}
+	nodes, err := c.NodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed listing nodes: %v", err)
+	}
+	for _, node := range nodes {
+		if !isAllSparkResource(node) {
+			continue
+		}
+		for _, addr := range node.Status.Addresses {
+			if addr.Address == host {
+				return node, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("node %q not found", host)
+}
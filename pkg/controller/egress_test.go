@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// newEgressAPIServer answers node patches with the patched annotations
+func newEgressAPIServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/api/v1/nodes/"):
+			var node v1.Node
+			data, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(data, &node); err != nil {
+				t.Errorf("failed decoding node patch: %v", err)
+			}
+			node.Name = strings.TrimPrefix(r.URL.Path, "/api/v1/nodes/")
+			json.NewEncoder(w).Encode(&node)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// newFRPSPodLister lists the frps pod of acme
+func newFRPSPodLister() corelister.PodLister {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "allspark"}}
+	pod.Status.PodIP = "10.32.0.5"
+	return corelister.NewPodLister(newIndexer(pod))
+}
+
+func newTenantNode(name, tunnelPort string, addresses ...string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{"allspark.sh/tenant": "acme"},
+	}}
+	if tunnelPort != "" {
+		node.Annotations = map[string]string{AnnotationKubeletTunnelPort: tunnelPort}
+	}
+	for _, addr := range addresses {
+		node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: addr})
+	}
+	return node
+}
+
+func TestKubeletTunnelAddress(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	ctrl := &ASController{
+		SystemPodLister: newFRPSPodLister(),
+		NodeLister: corelister.NewNodeLister(newIndexer(
+			newTenantNode("web1.site-a", "10000", "192.168.0.10"),
+			newTenantNode("web2.site-a", ""),
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}},
+		)),
+	}
+	tests := []struct {
+		hostport string
+		want     string
+	}{
+		{"web1.site-a:10250", "10.32.0.5:10000"},
+		{"192.168.0.10:10250", "10.32.0.5:10000"},
+		// the port must be the kubelet port
+		{"web1.site-a:22", ""},
+		// the tunnel port wasn't allocated yet
+		{"web2.site-a:10250", ""},
+		// not managed by allspark
+		{"master:10250", ""},
+		{"web3.site-a:10250", ""},
+		{"web1.site-a", ""},
+	}
+	for _, tt := range tests {
+		addr, err := ctrl.KubeletTunnelAddress(tt.hostport)
+		if addr != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("KubeletTunnelAddress(%q) = %q, %v, want %q", tt.hostport, addr, err, tt.want)
+		}
+	}
+}
+
+func TestEnsureKubeletTunnelPort(t *testing.T) {
+	apiServer := newEgressAPIServer(t)
+	defer apiServer.Close()
+
+	web1 := newTenantNode("web1.site-a", "10000")
+	web2 := newTenantNode("web2.site-a", "")
+	web3 := newTenantNode("web3.site-a", "")
+	ctrl := &ASController{
+		kubecli:    kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL}),
+		NodeLister: corelister.NewNodeLister(newIndexer(web1, web2, web3)),
+	}
+	if node, err := ctrl.ensureKubeletTunnelPort(web1, "acme"); err != nil || node != web1 {
+		t.Errorf("expected the allocated port to be kept, got %v", err)
+	}
+	node, err := ctrl.ensureKubeletTunnelPort(web2, "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port, _ := kubeletTunnelPort(node); port != 10001 {
+		t.Errorf("expected the lowest free port 10001, got %d", port)
+	}
+	// the lister didn't see the port of web2 yet, it's reserved in memory
+	node, err = ctrl.ensureKubeletTunnelPort(web3, "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port, _ := kubeletTunnelPort(node); port != 10002 {
+		t.Errorf("expected the port reserved to web2 to be skipped, got %d", port)
+	}
+}
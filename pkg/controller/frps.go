@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/request"
+	ini "gopkg.in/ini.v1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// frpsDashboardPort is the port of the frps dashboard api, it's reachable
+	// only through the ip of the frps pod
+	frpsDashboardPort = 7500
+	// frpsDashboardTimeout bounds each call to the dashboard api, retries included
+	frpsDashboardTimeout = 5 * time.Second
+	// AnnotationTunnelStatus holds the tenant status on each of its namespaces
+	AnnotationTunnelStatus = LabelPrefix + "/tunnel-status"
+	// AnnotationConfigHash holds the hash of the frps ini used by the frps pod
+	AnnotationConfigHash = LabelPrefix + "/config-hash"
+
+	frpsIniKey  = "frps.ini"
+	frpsIniPath = "/etc/frps"
+	// the credentials of the dashboard are generated for each tenant
+	// and kept on the frps secret along with the ini
+	frpsDashboardUserKey     = "dashboard-user"
+	frpsDashboardPasswordKey = "dashboard-password"
+)
+
+// frpsProxyTypes are the proxy types used by the tunnels of a tenant
+var frpsProxyTypes = []string{"http", "https", "tcp"}
+
+// renderFRPSConfig renders the frps ini of a tenant, the ports are
+// the ports of the frps container
+func (c *ASController) renderFRPSConfig(tenant, dashboardPassword string) ([]byte, error) {
+	meta := metav1.ObjectMeta{Name: tenant, Namespace: os.Getenv("POD_NAMESPACE")}
+	common := api.NewFRPSConfig(meta, 7000, 80, 443)
+	common.Token = c.cfg.FRPSToken
+	common.DashboardPort = frpsDashboardPort
+	common.DashboardUser = c.cfg.FRPSDashboardUser
+	common.DashboardPwd = dashboardPassword
+	common.AllowPorts = c.cfg.FRPSAllowPorts
+	common.SubdomainHost = c.SubdomainHost(tenant)
+
+	frpsini := ini.Empty()
+	sec, err := frpsini.NewSection("common")
+	if err != nil {
+		return nil, err
+	}
+	if err := sec.ReflectFrom(common); err != nil {
+		return nil, fmt.Errorf("failed injecting keys to section 'common': %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := frpsini.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SubdomainHost returns the base domain delegated to a tenant,
+// e.g.: acme.edge.example.com. Empty if it isn't configured.
+func (c *ASController) SubdomainHost(tenant string) string {
+	if c.cfg.TenantBaseDomain == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s", tenant, c.cfg.TenantBaseDomain)
+}
+
+// ConfigHash returns a short hash identifying a rendered config, it also
+// identifies the ini of an ingress on the watch of the ini-server
+func ConfigHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Tenants returns the name of all tenants found on namespaces
+func (c *ASController) Tenants() ([]string, error) {
+	namespaces, err := c.NamespaceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var tenants []string
+	for _, ns := range namespaces {
+		tenant := ns.Labels["allspark.sh/tenant"]
+		if tenant == "" || seen[tenant] {
+			continue
+		}
+		seen[tenant] = true
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// TenantStatus returns the last collected status of a tenant, nil if
+// the tenant wasn't polled yet
+func (c *ASController) TenantStatus(tenant string) *api.TenantStatus {
+	c.statusMu.RLock()
+	defer c.statusMu.RUnlock()
+	return c.tenantStatus[tenant]
+}
+
+// runTenantPoller polls the tenants periodically until stopCh is closed,
+// the interval is read on every iteration since it could be reloaded
+func (c *ASController) runTenantPoller(stopCh <-chan struct{}) {
+	for {
+		c.pollTenants()
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(time.Second * time.Duration(c.cfg.TenantResync())):
+		}
+	}
+}
+
+// pollTenants collects the status of every tenant frps and
+// publishes it on the namespaces of each tenant
+func (c *ASController) pollTenants() {
+	tenants, err := c.Tenants()
+	if err != nil {
+		glog.Warningf("failed listing tenants: %v", err)
+		return
+	}
+	current := make(map[string]*api.TenantStatus, len(tenants))
+	for _, tenant := range tenants {
+		status := c.collectTenantStatus(tenant)
+		current[tenant] = status
+		if err := c.publishTenantStatus(tenant, status); err != nil {
+			glog.Warningf("failed publishing status of tenant %q: %v", tenant, err)
+		}
+	}
+	c.statusMu.Lock()
+	c.tenantStatus = current
+	c.statusMu.Unlock()
+	// the nodes are tainted based on the status of its kubelet tunnel
+	c.enqueueTenantNodes()
+	// the frps pods may have been recreated with a new ip
+	c.enqueueDNS()
+}
+
+func (c *ASController) collectTenantStatus(tenant string) *api.TenantStatus {
+	status := &api.TenantStatus{LastUpdate: time.Now().UTC()}
+	info, err := c.FRPSServerInfo(tenant)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Online = true
+	status.Version = info.Version
+	c.checkFRPSVersion(tenant, info.Version)
+	status.ClientCounts = info.ClientCounts
+	status.CurrentConnections = info.CurrentConnections
+	status.TotalTrafficIn = info.TotalTrafficIn
+	status.TotalTrafficOut = info.TotalTrafficOut
+	for _, proxyType := range frpsProxyTypes {
+		proxies, err := c.FRPSProxies(tenant, proxyType)
+		if err != nil {
+			status.Error = err.Error()
+			continue
+		}
+		for _, p := range proxies.Proxies {
+			status.Proxies = append(status.Proxies, api.TenantProxy{
+				Name:               p.Name,
+				Type:               proxyType,
+				Status:             p.Status,
+				CurrentConnections: p.CurConns,
+				TodayTrafficIn:     p.TodayTrafficIn,
+				TodayTrafficOut:    p.TodayTrafficOut,
+			})
+		}
+	}
+	return status
+}
+
+// publishTenantStatus annotates all namespaces of a tenant with the summary of
+// its status, namespaces are only patched when the summary changes
+func (c *ASController) publishTenantStatus(tenant string, status *api.TenantStatus) error {
+	data, err := json.Marshal(status.Summary())
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{AnnotationTunnelStatus: string(data)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	selector := labels.SelectorFromSet(labels.Set{"allspark.sh/tenant": tenant})
+	namespaces, err := c.NamespaceLister.List(selector)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		if ns.Annotations[AnnotationTunnelStatus] == string(data) {
+			continue
+		}
+		_, err := c.kubecli.Core().Namespaces().Patch(ns.Name, types.MergePatchType, payload)
+		if err != nil {
+			return fmt.Errorf("failed patching namespace %q: %v", ns.Name, err)
+		}
+	}
+	return nil
+}
+
+// frpsPodIP returns the ip of the tenant frps pod
+func (c *ASController) frpsPodIP(tenant string) (string, error) {
+	pod, err := c.SystemPodLister.Pods(os.Getenv("POD_NAMESPACE")).Get(tenant)
+	if err != nil {
+		return "", fmt.Errorf("failed retrieving frps pod %q: %v", tenant, err)
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("frps pod %q doesn't have an ip yet", tenant)
+	}
+	return pod.Status.PodIP, nil
+}
+
+// frpsDashboardPassword returns the dashboard password stored on the frps
+// secret of a tenant, a random one is generated when there's none yet
+func (c *ASController) frpsDashboardPassword(tenant string) (string, error) {
+	secret, err := c.SecretLister.Secrets(os.Getenv("POD_NAMESPACE")).Get(tenant + "-frps")
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed retrieving frps secret of tenant %q: %v", tenant, err)
+	}
+	if err == nil && len(secret.Data[frpsDashboardPasswordKey]) > 0 {
+		return string(secret.Data[frpsDashboardPasswordKey]), nil
+	}
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("failed generating dashboard password: %v", err)
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// frpsDashboard returns a request to the dashboard api of the tenant frps
+func (c *ASController) frpsDashboard(tenant string) (*request.Request, error) {
+	podIP, err := c.frpsPodIP(tenant)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.SecretLister.Secrets(os.Getenv("POD_NAMESPACE")).Get(tenant + "-frps")
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving dashboard credentials of tenant %q: %v", tenant, err)
+	}
+	addr, _ := url.Parse(fmt.Sprintf("http://%s:%d", podIP, frpsDashboardPort))
+	return request.New(nil, addr).
+		Basic(string(secret.Data[frpsDashboardUserKey]), string(secret.Data[frpsDashboardPasswordKey])).
+		Timeout(frpsDashboardTimeout), nil
+}
+
+// FRPSServerInfo fetches the server info from the dashboard of the tenant frps
+func (c *ASController) FRPSServerInfo(tenant string) (*api.ServerInfo, error) {
+	req, err := c.frpsDashboard(tenant)
+	if err != nil {
+		return nil, err
+	}
+	var info api.ServerInfo
+	if err := req.Resource("/api/serverinfo").Do().Into(&info); err != nil {
+		return nil, fmt.Errorf("failed fetching frps server info for tenant %q: %v", tenant, err)
+	}
+	return &info, nil
+}
+
+// FRPSProxies fetches the proxies of a given type from the dashboard of the tenant frps
+func (c *ASController) FRPSProxies(tenant, proxyType string) (*api.FRPSProxies, error) {
+	req, err := c.frpsDashboard(tenant)
+	if err != nil {
+		return nil, err
+	}
+	var proxies api.FRPSProxies
+	if err := req.Resource("/api/proxy/" + proxyType).Do().Into(&proxies); err != nil {
+		return nil, fmt.Errorf("failed fetching frps %s proxies for tenant %q: %v", proxyType, tenant, err)
+	}
+	return &proxies, nil
+}
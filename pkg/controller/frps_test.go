@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+	ini "gopkg.in/ini.v1"
+)
+
+// fakeAPIServer records the patched namespaces
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	patches map[string][]byte
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const nsPath = "/api/v1/namespaces/"
+	name := strings.TrimPrefix(r.URL.Path, nsPath)
+	if r.Method != "PATCH" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	data, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.patches[name] = data
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+}
+
+func TestPollTenants(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	fake := &fakeAPIServer{patches: map[string][]byte{}}
+	apiServer := httptest.NewServer(fake)
+	defer apiServer.Close()
+
+	ns := func(name, tenant string) *v1.Namespace {
+		n := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if tenant != "" {
+			n.Labels = map[string]string{"allspark.sh/tenant": tenant}
+		}
+		return n
+	}
+	ctrl := &ASController{
+		kubecli:         kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL}),
+		SystemPodLister: corelister.NewPodLister(newIndexer()),
+		NamespaceLister: corelister.NewNamespaceLister(newIndexer(
+			ns("acme-dev", "acme"),
+			ns("acme-prod", "acme"),
+			ns("default", ""),
+		)),
+		NodeLister: corelister.NewNodeLister(newIndexer(
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web1", Labels: map[string]string{"allspark.sh/tenant": "acme"}}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}},
+		)),
+		nodeQueue: NewTaskQueue("node-operator", func(string) error { return nil }),
+		cfg:       &conf.Config{},
+	}
+	if ctrl.TenantStatus("acme") != nil {
+		t.Fatalf("expected no status before polling")
+	}
+	ctrl.pollTenants()
+
+	if ctrl.nodeQueue.Len() != 1 {
+		t.Errorf("expected the nodes of the tenants to be requeued, got %d", ctrl.nodeQueue.Len())
+	}
+	status := ctrl.TenantStatus("acme")
+	if status == nil {
+		t.Fatalf("expected the status of tenant acme")
+	}
+	if status.Online || status.Error == "" {
+		t.Errorf("expected the tenant to be offline with an error, got %#v", status)
+	}
+	if len(fake.patches) != 2 {
+		t.Fatalf("expected the namespaces of the tenant to be patched, got %v", fake.patches)
+	}
+	for _, name := range []string{"acme-dev", "acme-prod"} {
+		var patch struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(fake.patches[name], &patch); err != nil {
+			t.Fatalf("failed decoding patch of %q: %v", name, err)
+		}
+		var published api.TenantStatus
+		if err := json.Unmarshal([]byte(patch.Metadata.Annotations[AnnotationTunnelStatus]), &published); err != nil {
+			t.Fatalf("failed decoding the status annotation of %q: %v", name, err)
+		}
+		if published.Error != status.Error {
+			t.Errorf("expected %q to be annotated with the error %q, got %q", name, status.Error, published.Error)
+		}
+	}
+
+	// namespaces already annotated with the same summary aren't patched again
+	summary, _ := json.Marshal(status.Summary())
+	annotated := ns("acme-dev", "acme")
+	annotated.Annotations = map[string]string{AnnotationTunnelStatus: string(summary)}
+	ctrl.NamespaceLister = corelister.NewNamespaceLister(newIndexer(annotated, ns("acme-prod", "acme")))
+	fake.patches = map[string][]byte{}
+	ctrl.pollTenants()
+	if _, ok := fake.patches["acme-dev"]; ok || len(fake.patches) != 1 {
+		t.Errorf("expected only the namespace with a stale status to be patched, got %v", fake.patches)
+	}
+}
+
+func TestTenantStatusSummary(t *testing.T) {
+	status := &api.TenantStatus{Online: true, CurrentConnections: 3, Proxies: []api.TenantProxy{
+		{Name: "web", Type: "https", Status: "online", CurrentConnections: 2},
+		{Name: "web", Type: "http", Status: "online", CurrentConnections: 1},
+		{Name: "db", Type: "tcp", Status: "offline"},
+	}}
+	idle := &api.TenantStatus{Online: true, Proxies: []api.TenantProxy{
+		{Name: "db", Type: "tcp", Status: "offline"},
+		{Name: "web", Type: "http", Status: "online"},
+		{Name: "web", Type: "https", Status: "online"},
+	}}
+	if !reflect.DeepEqual(status.Summary(), idle.Summary()) {
+		t.Errorf("expected the summary to ignore counters and order, got %#v and %#v", status.Summary(), idle.Summary())
+	}
+	if p := status.Summary().Proxies[1]; p.Name != "web" || p.Type != "http" {
+		t.Errorf("expected the proxies sorted by name and type, got %#v", status.Summary().Proxies)
+	}
+}
+
+func TestTenantStatusProxy(t *testing.T) {
+	status := &api.TenantStatus{Proxies: []api.TenantProxy{
+		{Name: "web", Type: "http", Status: "online"},
+		{Name: "db", Type: "tcp", Status: "offline"},
+	}}
+	if p := status.Proxy("db"); p == nil || p.Type != "tcp" {
+		t.Errorf("expected the tcp proxy db, got %#v", p)
+	}
+	if p := status.Proxy("missing"); p != nil {
+		t.Errorf("expected no proxy, got %#v", p)
+	}
+}
+
+func TestRenderFRPSConfig(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	ctrl := &ASController{cfg: &conf.Config{
+		FRPSToken:         "secret",
+		FRPSDashboardUser: "admin",
+	}}
+	data, err := ctrl.renderFRPSConfig("acme", "pwd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frpsini, err := ini.Load(data)
+	if err != nil {
+		t.Fatalf("failed loading the rendered ini: %v", err)
+	}
+	common := frpsini.Section("common")
+	for key, expected := range map[string]string{
+		"bind_port":        "7000",
+		"vhost_http_port":  "80",
+		"vhost_https_port": "443",
+		"token":            "secret",
+		"dashboard_port":   "7500",
+		"dashboard_user":   "admin",
+		"dashboard_pwd":    "pwd",
+	} {
+		if got := common.Key(key).String(); got != expected {
+			t.Errorf("expected %s=%q, got %q", key, expected, got)
+		}
+	}
+	if common.HasKey("allow_ports") || common.HasKey("subdomain_host") {
+		t.Errorf("expected empty optional keys to be omitted, got %v", common.KeyStrings())
+	}
+
+	again, err := ctrl.renderFRPSConfig("acme", "pwd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ConfigHash(data) != ConfigHash(again) {
+		t.Errorf("expected the config hash to be stable, got %q and %q", ConfigHash(data), ConfigHash(again))
+	}
+	if len(ConfigHash(data)) != 16 {
+		t.Errorf("expected a short config hash, got %q", ConfigHash(data))
+	}
+	ctrl.cfg.FRPSToken = "rotated"
+	changed, err := ctrl.renderFRPSConfig("acme", "pwd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ConfigHash(data) == ConfigHash(changed) {
+		t.Errorf("expected the config hash to change with the token")
+	}
+}
+
+func TestNewFRPSPod(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme-dev", UID: "uid"}}
+	ctrl := &ASController{cfg: &conf.Config{ContainerImage: "frp:v1"}}
+
+	secret := newFRPSSecret(ns, "acme", []byte("[common]\n"), "admin", "pwd")
+	if secret.Name != "acme-frps" || string(secret.Data[frpsIniKey]) != "[common]\n" {
+		t.Errorf("expected the ini in the secret acme-frps, got %q: %v", secret.Name, secret.Data)
+	}
+	if string(secret.Data[frpsDashboardUserKey]) != "admin" || string(secret.Data[frpsDashboardPasswordKey]) != "pwd" {
+		t.Errorf("expected the dashboard credentials in the secret, got %v", secret.Data)
+	}
+	pod := ctrl.newFRPSPod(ns, "acme", "0123456789abcdef")
+	if got := pod.Annotations[AnnotationConfigHash]; got != "0123456789abcdef" {
+		t.Errorf("expected the config hash annotation, got %q", got)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].Secret == nil || pod.Spec.Volumes[0].Secret.SecretName != secret.Name {
+		t.Errorf("expected the pod to mount the secret %q, got %#v", secret.Name, pod.Spec.Volumes)
+	}
+	if cmd := pod.Spec.Containers[0].Command; strings.Join(cmd, " ") != "frps -c /etc/frps/frps.ini" {
+		t.Errorf("expected frps to load the mounted ini, got %v", cmd)
+	}
+}
+
+func TestFRPSDashboardPassword(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	ctrl := &ASController{SecretLister: corelister.NewSecretLister(newIndexer(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme-frps", Namespace: "allspark"},
+		Data:       map[string][]byte{frpsDashboardPasswordKey: []byte("pwd")},
+	}))}
+	if pwd, err := ctrl.frpsDashboardPassword("acme"); err != nil || pwd != "pwd" {
+		t.Errorf("expected the password of the secret, got %q (%v)", pwd, err)
+	}
+	first, err := ctrl.frpsDashboardPassword("globex")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := ctrl.frpsDashboardPassword("globex")
+	if len(first) != 48 || first == second {
+		t.Errorf("expected random passwords for tenants without a secret, got %q and %q", first, second)
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	allocatedPortsDesc = prometheus.NewDesc(
+		"allspark_controller_allocated_ports",
+		"Number of ports allocated to frps services per node ip.",
+		[]string{"node_ip"}, nil,
+	)
+	tenantsDesc = prometheus.NewDesc(
+		"allspark_controller_tenants",
+		"Number of tenants managed by the controller.",
+		nil, nil,
+	)
+	frpsUpDesc = prometheus.NewDesc(
+		"allspark_frps_up",
+		"Whether the dashboard of the tenant frps could be scraped.",
+		[]string{"tenant"}, nil,
+	)
+	frpsTrafficInDesc = prometheus.NewDesc(
+		"allspark_frps_traffic_in_bytes_total",
+		"Total traffic received by the tenant frps.",
+		[]string{"tenant"}, nil,
+	)
+	frpsTrafficOutDesc = prometheus.NewDesc(
+		"allspark_frps_traffic_out_bytes_total",
+		"Total traffic sent by the tenant frps.",
+		[]string{"tenant"}, nil,
+	)
+	frpsConnectionsDesc = prometheus.NewDesc(
+		"allspark_frps_current_connections",
+		"Current connections of the tenant frps.",
+		[]string{"tenant"}, nil,
+	)
+	frpsClientsDesc = prometheus.NewDesc(
+		"allspark_frps_clients",
+		"Number of frpc clients connected to the tenant frps.",
+		[]string{"tenant"}, nil,
+	)
+	componentVersionsDesc = prometheus.NewDesc(
+		"allspark_component_versions",
+		"Number of ini-sync, frpc and frps instances by version and if it's supported by the controller.",
+		[]string{"component", "version", "supported"}, nil,
+	)
+)
+
+// Collector exposes the state of the controller and the last
+// status collected from the dashboard of each tenant frps
+type Collector struct {
+	ctrl *ASController
+}
+
+func NewCollector(ctrl *ASController) *Collector {
+	return &Collector{ctrl: ctrl}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- allocatedPortsDesc
+	ch <- tenantsDesc
+	ch <- frpsUpDesc
+	ch <- frpsTrafficInDesc
+	ch <- frpsTrafficOutDesc
+	ch <- frpsConnectionsDesc
+	ch <- frpsClientsDesc
+	ch <- componentVersionsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectPorts(ch)
+	c.collectVersions(ch)
+	tenants, err := c.ctrl.Tenants()
+	if err != nil {
+		glog.Warningf("failed listing tenants: %v", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(tenantsDesc, prometheus.GaugeValue, float64(len(tenants)))
+	for _, tenant := range tenants {
+		status := c.ctrl.TenantStatus(tenant)
+		if status == nil || !status.Online {
+			ch <- prometheus.MustNewConstMetric(frpsUpDesc, prometheus.GaugeValue, 0, tenant)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(frpsUpDesc, prometheus.GaugeValue, 1, tenant)
+		ch <- prometheus.MustNewConstMetric(frpsTrafficInDesc, prometheus.CounterValue, float64(status.TotalTrafficIn), tenant)
+		ch <- prometheus.MustNewConstMetric(frpsTrafficOutDesc, prometheus.CounterValue, float64(status.TotalTrafficOut), tenant)
+		ch <- prometheus.MustNewConstMetric(frpsConnectionsDesc, prometheus.GaugeValue, float64(status.CurrentConnections), tenant)
+		ch <- prometheus.MustNewConstMetric(frpsClientsDesc, prometheus.GaugeValue, float64(status.ClientCounts), tenant)
+	}
+}
+
+func (c *Collector) collectVersions(ch chan<- prometheus.Metric) {
+	for component, versions := range c.ctrl.componentVersions() {
+		for v, count := range versions {
+			supported := strconv.FormatBool(c.ctrl.versionSupported(component, v))
+			ch <- prometheus.MustNewConstMetric(componentVersionsDesc, prometheus.GaugeValue, float64(count), component, v, supported)
+		}
+	}
+}
+
+func (c *Collector) collectPorts(ch chan<- prometheus.Metric) {
+	services, err := c.ctrl.ServiceLister.Services(os.Getenv("POD_NAMESPACE")).List(labels.Everything())
+	if err != nil {
+		glog.Warningf("failed listing services: %v", err)
+		return
+	}
+	allocated := map[string]int{}
+	for _, svc := range services {
+		for _, externalIP := range svc.Spec.ExternalIPs {
+			allocated[externalIP] += len(svc.Spec.Ports)
+		}
+	}
+	for ip, count := range allocated {
+		ch <- prometheus.MustNewConstMetric(allocatedPortsDesc, prometheus.GaugeValue, float64(count), ip)
+	}
+}
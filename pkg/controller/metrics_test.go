@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+)
+
+func newIndexer(objs ...interface{}) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		indexer.Add(obj)
+	}
+	return indexer
+}
+
+// gather collects the metrics of the collector by its name and first label value
+func gather(t *testing.T, c prometheus.Collector) map[string]map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed gathering metrics: %v", err)
+	}
+	values := map[string]map[string]float64{}
+	for _, mf := range families {
+		values[mf.GetName()] = map[string]float64{}
+		for _, m := range mf.GetMetric() {
+			var label string
+			if len(m.GetLabel()) > 0 {
+				label = m.GetLabel()[0].GetValue()
+			}
+			values[mf.GetName()][label] = metricValue(m)
+		}
+	}
+	return values
+}
+
+func metricValue(m *dto.Metric) float64 {
+	if m.GetGauge() != nil {
+		return m.GetGauge().GetValue()
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestCollector(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	svc := func(name string, ports int, ips ...string) *v1.Service {
+		s := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "allspark"}}
+		s.Spec.ExternalIPs = ips
+		for i := 0; i < ports; i++ {
+			s.Spec.Ports = append(s.Spec.Ports, v1.ServicePort{Port: int32(30000 + i)})
+		}
+		return s
+	}
+	ns := func(name, tenant string) *v1.Namespace {
+		n := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if tenant != "" {
+			n.Labels = map[string]string{"allspark.sh/tenant": tenant}
+		}
+		return n
+	}
+	ctrl := &ASController{
+		ServiceLister: corelister.NewServiceLister(newIndexer(
+			svc("acme", 3, "10.0.0.1"),
+			svc("globex", 2, "10.0.0.1", "10.0.0.2"),
+			svc("kube-dns", 1),
+		)),
+		NamespaceLister: corelister.NewNamespaceLister(newIndexer(
+			ns("acme-dev", "acme"),
+			ns("acme-prod", "acme"),
+			ns("globex", "globex"),
+			ns("default", ""),
+		)),
+		// the frps of globex wasn't reachable on the last poll
+		tenantStatus: map[string]*api.TenantStatus{
+			"acme":   {Online: true, Version: "0.21.0", ClientCounts: 4, TotalTrafficIn: 1024},
+			"globex": {Error: "connection refused"},
+		},
+		skew: newVersionSkew(&conf.Config{}),
+	}
+
+	values := gather(t, NewCollector(ctrl))
+	for _, tc := range []struct {
+		metric, label string
+		expected      float64
+	}{
+		{"allspark_controller_allocated_ports", "10.0.0.1", 5},
+		{"allspark_controller_allocated_ports", "10.0.0.2", 2},
+		{"allspark_controller_tenants", "", 2},
+		{"allspark_frps_up", "acme", 1},
+		{"allspark_frps_up", "globex", 0},
+		{"allspark_frps_clients", "acme", 4},
+		{"allspark_frps_traffic_in_bytes_total", "acme", 1024},
+		{"allspark_component_versions", ComponentFRPS, 1},
+	} {
+		got, ok := values[tc.metric][tc.label]
+		if !ok {
+			t.Errorf("expected metric %s{%q}, got %v", tc.metric, tc.label, values[tc.metric])
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("expected metric %s{%q} to be %v, got %v", tc.metric, tc.label, tc.expected, got)
+		}
+	}
+	if _, ok := values["allspark_frps_clients"]["globex"]; ok {
+		t.Errorf("expected no frps metrics of tenants that couldn't be scraped")
+	}
+}
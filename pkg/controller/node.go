@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/api"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// TaintTunnelUnreachable prevents scheduling pods on nodes with the kubelet tunnel down
+	TaintTunnelUnreachable = LabelPrefix + "/tunnel-unreachable"
+	// NodeTunnelReachable reports if the kubelet of the node is reachable through its tunnel
+	NodeTunnelReachable v1.NodeConditionType = "TunnelReachable"
+	// AnnotationKubeletService is the name of the kubelet service of the node,
+	// the kubelet proxy on the tenant frps is named after it
+	AnnotationKubeletService = LabelPrefix + "/kubelet-service"
+
+	// MessageKubeletServiceExists is the error of a kubelet service name
+	// taken by a service that isn't controlled by the node
+	MessageKubeletServiceExists = "Service %q already exists and is not managed by node %q"
+
+	kubeletServicePrefix = "kubelet-"
+	kubeletServiceHash   = 10
+)
+
+var invalidServiceChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// KubeletServiceName derives the kubelet service name of a node, the hash
+// of the full node name keeps nodes sharing the same hostname apart
+func KubeletServiceName(nodeName string) string {
+	sum := sha256.Sum256([]byte(nodeName))
+	hash := hex.EncodeToString(sum[:])[:kubeletServiceHash]
+	name := invalidServiceChars.ReplaceAllString(strings.ToLower(strings.Split(nodeName, ".")[0]), "-")
+	if max := validation.DNS1035LabelMaxLength - len(kubeletServicePrefix) - kubeletServiceHash - 1; len(name) > max {
+		name = name[:max]
+	}
+	name = strings.Trim(name, "-")
+	if name == "" {
+		return kubeletServicePrefix + hash
+	}
+	return kubeletServicePrefix + name + "-" + hash
+}
+
+// ensureKubeletServiceName annotates the node with its kubelet service name
+func (c *ASController) ensureKubeletServiceName(node *v1.Node) (*v1.Node, string, error) {
+	serviceName := node.Annotations[AnnotationKubeletService]
+	if serviceName != "" && len(validation.IsDNS1035Label(serviceName)) == 0 {
+		return node, serviceName, nil
+	}
+	serviceName = KubeletServiceName(node.Name)
+	payload := fmt.Sprintf(`{"metadata": {"annotations": {%q: %q}}}`, AnnotationKubeletService, serviceName)
+	n, err := c.kubecli.Core().Nodes().Patch(node.Name, types.MergePatchType, []byte(payload))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed annotating kubelet service of node %q: %v", node.Name, err)
+	}
+	glog.Infof("node %q mapped to kubelet service %q", node.Name, serviceName)
+	return n, serviceName, nil
+}
+
+// legacyKubeletServiceName is the name of the kubelet service of a node before
+// they were hashed, the first segment of the node name
+func legacyKubeletServiceName(nodeName string) string {
+	return strings.Split(nodeName, ".")[0]
+}
+
+// deleteStaleKubeletServices removes the services owned by the node other than
+// its current kubelet service. The legacy service named after its hostname is
+// kept while the dns hosts are disabled, '<node>.<namespace>.svc' only resolves
+// through it then.
+func (c *ASController) deleteStaleKubeletServices(node *v1.Node, serviceName string) error {
+	namespace := os.Getenv("POD_NAMESPACE")
+	services, err := c.ServiceLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed listing services: %v", err)
+	}
+	for _, svc := range services {
+		ref := metav1.GetControllerOf(svc)
+		if svc.Name == serviceName || ref == nil || ref.Kind != "Node" || ref.UID != node.UID {
+			continue
+		}
+		if c.cfg.DNSConfigMap == "" && svc.Name == legacyKubeletServiceName(node.Name) {
+			if err := c.reconcileLegacyKubeletService(svc, node); err != nil {
+				return err
+			}
+			continue
+		}
+		err := c.kubecli.Core().Services(namespace).Delete(svc.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed deleting stale kubelet service %q: %v", svc.Name, err)
+		}
+		glog.Infof("deleted stale kubelet service %q of node %q", svc.Name, node.Name)
+	}
+	return nil
+}
+
+// reconcileLegacyKubeletService keeps the selector of the legacy kubelet
+// service in sync when the node moves tenant
+func (c *ASController) reconcileLegacyKubeletService(svc *v1.Service, node *v1.Node) error {
+	updated, changed := reconcileKubeletService(svc, newKubeletService(svc.Name, node))
+	if !changed {
+		return nil
+	}
+	if _, err := c.kubecli.Core().Services(svc.Namespace).Update(updated); err != nil {
+		return fmt.Errorf("failed updating legacy kubelet service %q: %v", svc.Name, err)
+	}
+	glog.Infof("reconciled legacy kubelet service %q of node %q", svc.Name, node.Name)
+	return nil
+}
+
+// kubeletTunnelState checks the kubelet proxy of a node in the tenant status,
+// known is false when the status of the tenant frps couldn't be collected
+func kubeletTunnelState(status *api.TenantStatus, proxyName string) (reachable, known bool, message string) {
+	if status == nil || !status.Online {
+		return false, false, ""
+	}
+	proxy := status.Proxy(proxyName)
+	if proxy == nil {
+		return false, true, fmt.Sprintf("kubelet proxy %q not found on tenant frps", proxyName)
+	}
+	if !proxy.IsOnline() {
+		return false, true, fmt.Sprintf("kubelet proxy %q is %s", proxyName, proxy.Status)
+	}
+	return true, true, fmt.Sprintf("kubelet proxy %q is online", proxyName)
+}
+
+// syncNodeTunnel taints and sets the condition of a node based on the
+// status of its kubelet proxy on the tenant frps
+func (c *ASController) syncNodeTunnel(node *v1.Node, tenant, proxyName string) error {
+	reachable, known, message := kubeletTunnelState(c.TenantStatus(tenant), proxyName)
+	if !known {
+		glog.V(2).Infof("tunnel status of node %q is unknown, tenant %q wasn't polled", node.Name, tenant)
+		return nil
+	}
+	if updated, changed := setTunnelTaint(node, !reachable); changed {
+		n, err := c.kubecli.Core().Nodes().Update(updated)
+		if err != nil {
+			return fmt.Errorf("failed updating taints of node %q: %v", node.Name, err)
+		}
+		glog.Infof("node %q tunnel reachable=%v: %s", node.Name, reachable, message)
+		node = n
+	}
+	if updated, changed := setTunnelCondition(node, reachable, message); changed {
+		if _, err := c.kubecli.Core().Nodes().UpdateStatus(updated); err != nil {
+			return fmt.Errorf("failed updating condition of node %q: %v", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// enqueueTenantNodes requeues all nodes of the tenants
+func (c *ASController) enqueueTenantNodes() {
+	selector, err := labels.Parse("allspark.sh/tenant")
+	if err != nil {
+		glog.Warningf("failed parsing selector: %v", err)
+		return
+	}
+	nodes, err := c.NodeLister.List(selector)
+	if err != nil {
+		glog.Warningf("failed listing nodes: %v", err)
+		return
+	}
+	for _, node := range nodes {
+		c.nodeQueue.Add(node)
+	}
+}
+
+// setTunnelTaint returns a copy of the node with the unreachable taint added
+// or removed, changed is false when the node already matches
+func setTunnelTaint(node *v1.Node, unreachable bool) (*v1.Node, bool) {
+	var taints []v1.Taint
+	found := false
+	for _, t := range node.Spec.Taints {
+		if t.Key == TaintTunnelUnreachable {
+			found = true
+			continue
+		}
+		taints = append(taints, t)
+	}
+	if found == unreachable {
+		return node, false
+	}
+	if unreachable {
+		now := metav1.Now()
+		taints = append(taints, v1.Taint{
+			Key:       TaintTunnelUnreachable,
+			Effect:    v1.TaintEffectNoSchedule,
+			TimeAdded: &now,
+		})
+	}
+	updated := node.DeepCopy()
+	updated.Spec.Taints = taints
+	return updated, true
+}
+
+// setTunnelCondition returns a copy of the node with the tunnel condition, the
+// transition time only moves when the status flips. changed is false when the
+// condition already has the same status and message.
+func setTunnelCondition(node *v1.Node, reachable bool, message string) (*v1.Node, bool) {
+	now := metav1.NewTime(time.Now())
+	condition := v1.NodeCondition{
+		Type:               NodeTunnelReachable,
+		Status:             v1.ConditionFalse,
+		Reason:             "KubeletTunnelOffline",
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	if reachable {
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "KubeletTunnelOnline"
+	}
+
+	updated := node.DeepCopy()
+	for i, cond := range updated.Status.Conditions {
+		if cond.Type != NodeTunnelReachable {
+			continue
+		}
+		if cond.Status == condition.Status && cond.Reason == condition.Reason && cond.Message == condition.Message {
+			return node, false
+		}
+		if cond.Status == condition.Status {
+			condition.LastTransitionTime = cond.LastTransitionTime
+		}
+		updated.Status.Conditions[i] = condition
+		return updated, true
+	}
+	updated.Status.Conditions = append(updated.Status.Conditions, condition)
+	return updated, true
+}
+
+// syncKubeletService creates the kubelet service of a node or reconciles the
+// selector, ports and labels when the node moves tenant or the service is edited
+func (c *ASController) syncKubeletService(node *v1.Node, serviceName string) error {
+	namespace := os.Getenv("POD_NAMESPACE")
+	desired := newKubeletService(serviceName, node)
+	svc, err := c.ServiceLister.Services(namespace).Get(serviceName)
+	if errors.IsNotFound(err) {
+		if _, err := c.kubecli.Core().Services(namespace).Create(desired); err != nil {
+			return fmt.Errorf("failed creating kubelet service %q: %v", serviceName, err)
+		}
+		glog.Infof("created kubelet service %q of node %q", serviceName, node.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed getting service %q: %v", serviceName, err)
+	}
+	if ref := metav1.GetControllerOf(svc); ref == nil || ref.UID != node.UID {
+		return fmt.Errorf(MessageKubeletServiceExists, serviceName, node.Name)
+	}
+	updated, changed := reconcileKubeletService(svc, desired)
+	if !changed {
+		return nil
+	}
+	if _, err := c.kubecli.Core().Services(namespace).Update(updated); err != nil {
+		return fmt.Errorf("failed updating kubelet service %q: %v", serviceName, err)
+	}
+	glog.Infof("reconciled kubelet service %q of node %q", serviceName, node.Name)
+	return nil
+}
+
+// reconcileKubeletService returns a copy of the service with the selector,
+// ports and labels of the desired one, changed is false when they match
+func reconcileKubeletService(svc, desired *v1.Service) (*v1.Service, bool) {
+	changed := false
+	updated := svc.DeepCopy()
+	if !reflect.DeepEqual(svc.Spec.Selector, desired.Spec.Selector) {
+		updated.Spec.Selector = desired.Spec.Selector
+		changed = true
+	}
+	if !equalServicePorts(svc.Spec.Ports, desired.Spec.Ports) {
+		updated.Spec.Ports = desired.Spec.Ports
+		changed = true
+	}
+	for key, value := range desired.Labels {
+		if svc.Labels[key] != value {
+			if updated.Labels == nil {
+				updated.Labels = map[string]string{}
+			}
+			updated.Labels[key] = value
+			changed = true
+		}
+	}
+	return updated, changed
+}
+
+// equalServicePorts compares the fields set by the controller, defaulted
+// fields like the node port are ignored
+func equalServicePorts(a, b []v1.ServicePort) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Protocol != b[i].Protocol ||
+			a[i].Port != b[i].Port || a[i].TargetPort != b[i].TargetPort {
+			return false
+		}
+	}
+	return true
+}
+
+// enqueueServiceNode requeues the node owning a kubelet service
+func (c *ASController) enqueueServiceNode(obj interface{}) {
+	meta, ok := objectFromEvent(obj)
+	if !ok {
+		return
+	}
+	if ref := metav1.GetControllerOf(meta); ref != nil && ref.Kind == "Node" {
+		c.nodeQueue.AddKey(ref.Name)
+	}
+}
@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestKubeletServiceName(t *testing.T) {
+	tests := []struct {
+		node       string
+		wantPrefix string
+	}{
+		{"web1", "kubelet-web1-"},
+		{"web1.site-a", "kubelet-web1-"},
+		{"Web_1.site-a", "kubelet-web-1-"},
+		{"-web-.site-a", "kubelet-web-"},
+		{"___.site-a", "kubelet-"},
+		{strings.Repeat("a", 80) + ".site-a", "kubelet-" + strings.Repeat("a", 44) + "-"},
+	}
+	for _, tt := range tests {
+		name := KubeletServiceName(tt.node)
+		if !strings.HasPrefix(name, tt.wantPrefix) || len(name) != len(tt.wantPrefix)+kubeletServiceHash {
+			t.Errorf("KubeletServiceName(%q) = %q, want %q followed by the hash", tt.node, name, tt.wantPrefix)
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			t.Errorf("KubeletServiceName(%q) = %q isn't a valid service name: %v", tt.node, name, errs)
+		}
+		if again := KubeletServiceName(tt.node); again != name {
+			t.Errorf("KubeletServiceName(%q) isn't stable: %q and %q", tt.node, name, again)
+		}
+	}
+	if a, b := KubeletServiceName("web1.site-a"), KubeletServiceName("web1.site-b"); a == b {
+		t.Errorf("nodes sharing a hostname are mapped to the same service %q", a)
+	}
+}
+
+func TestKubeletTunnelState(t *testing.T) {
+	status := &api.TenantStatus{
+		Online: true,
+		Proxies: []api.TenantProxy{
+			{Name: "kubelet-web1-0123456789", Type: "https", Status: "online"},
+			{Name: "kubelet-web2-0123456789", Type: "https", Status: "offline"},
+		},
+	}
+	tests := []struct {
+		name          string
+		status        *api.TenantStatus
+		proxy         string
+		wantReachable bool
+		wantKnown     bool
+	}{
+		{"not polled", nil, "kubelet-web1-0123456789", false, false},
+		{"frps offline", &api.TenantStatus{Online: false}, "kubelet-web1-0123456789", false, false},
+		{"proxy online", status, "kubelet-web1-0123456789", true, true},
+		{"proxy offline", status, "kubelet-web2-0123456789", false, true},
+		{"proxy not found", status, "kubelet-web3-0123456789", false, true},
+	}
+	for _, tt := range tests {
+		reachable, known, message := kubeletTunnelState(tt.status, tt.proxy)
+		if reachable != tt.wantReachable || known != tt.wantKnown {
+			t.Errorf("%s: kubeletTunnelState() = %v, %v, want %v, %v", tt.name, reachable, known, tt.wantReachable, tt.wantKnown)
+		}
+		if known && !strings.Contains(message, tt.proxy) {
+			t.Errorf("%s: kubeletTunnelState() message %q doesn't name the proxy", tt.name, message)
+		}
+	}
+}
+
+func TestSetTunnelTaint(t *testing.T) {
+	other := v1.Taint{Key: "dedicated", Value: "db", Effect: v1.TaintEffectNoSchedule}
+	unreachable := v1.Taint{Key: TaintTunnelUnreachable, Effect: v1.TaintEffectNoSchedule}
+	tests := []struct {
+		name        string
+		taints      []v1.Taint
+		unreachable bool
+		wantChanged bool
+		wantTaints  []string
+	}{
+		{"taint reachable node", []v1.Taint{other}, true, true, []string{"dedicated", TaintTunnelUnreachable}},
+		{"already tainted", []v1.Taint{other, unreachable}, true, false, []string{"dedicated", TaintTunnelUnreachable}},
+		{"untaint", []v1.Taint{unreachable, other}, false, true, []string{"dedicated"}},
+		{"already untainted", []v1.Taint{other}, false, false, []string{"dedicated"}},
+	}
+	for _, tt := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web1"}}
+		node.Spec.Taints = tt.taints
+		updated, changed := setTunnelTaint(node, tt.unreachable)
+		if changed != tt.wantChanged {
+			t.Errorf("%s: expected changed=%v, got %v", tt.name, tt.wantChanged, changed)
+		}
+		var keys []string
+		for _, taint := range updated.Spec.Taints {
+			keys = append(keys, taint.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.wantTaints, ",") {
+			t.Errorf("%s: expected taints %v, got %v", tt.name, tt.wantTaints, keys)
+		}
+		if changed && len(node.Spec.Taints) != len(tt.taints) {
+			t.Errorf("%s: the given node was modified", tt.name)
+		}
+	}
+}
+
+func TestSetTunnelCondition(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web1"}}
+	node, changed := setTunnelCondition(node, false, "kubelet proxy \"web1\" is offline")
+	if !changed || len(node.Status.Conditions) != 1 {
+		t.Fatalf("expected the condition to be added, got %v", node.Status.Conditions)
+	}
+	if cond := node.Status.Conditions[0]; cond.Type != NodeTunnelReachable || cond.Status != v1.ConditionFalse {
+		t.Fatalf("expected an unreachable tunnel condition, got %#v", cond)
+	}
+	if _, changed := setTunnelCondition(node, false, "kubelet proxy \"web1\" is offline"); changed {
+		t.Errorf("expected no change for the same status and message")
+	}
+
+	// the transition time moves only when the status flips
+	transition := metav1.NewTime(time.Now().Add(-time.Hour))
+	node.Status.Conditions[0].LastTransitionTime = transition
+	updated, changed := setTunnelCondition(node, false, "kubelet proxy \"web1\" not found on tenant frps")
+	if !changed {
+		t.Fatalf("expected the condition to change with the message")
+	}
+	if got := updated.Status.Conditions[0].LastTransitionTime; !got.Equal(&transition) {
+		t.Errorf("expected the transition time %v to be kept, got %v", transition, got)
+	}
+	updated, changed = setTunnelCondition(node, true, "kubelet proxy \"web1\" is online")
+	cond := updated.Status.Conditions[0]
+	if !changed || cond.Status != v1.ConditionTrue || cond.Reason != "KubeletTunnelOnline" {
+		t.Fatalf("expected a reachable tunnel condition, got %#v", cond)
+	}
+	if cond.LastTransitionTime.Equal(&transition) {
+		t.Errorf("expected the transition time to move when the status flips")
+	}
+}
+
+func TestEnsureKubeletServiceName(t *testing.T) {
+	apiServer := newEgressAPIServer(t)
+	defer apiServer.Close()
+	ctrl := &ASController{kubecli: kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL})}
+
+	mapped := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web1.site-a",
+		Annotations: map[string]string{AnnotationKubeletService: "kubelet-custom"},
+	}}
+	if node, name, err := ctrl.ensureKubeletServiceName(mapped); err != nil || node != mapped || name != "kubelet-custom" {
+		t.Errorf("expected the annotated service to be kept, got %q, %v", name, err)
+	}
+	for _, annotation := range []string{"", "Invalid_Name"} {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web1.site-a",
+			Annotations: map[string]string{AnnotationKubeletService: annotation},
+		}}
+		patched, name, err := ctrl.ensureKubeletServiceName(node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := KubeletServiceName(node.Name); name != want || patched.Annotations[AnnotationKubeletService] != want {
+			t.Errorf("annotation %q: expected the node to be annotated with %q, got %q", annotation, want, name)
+		}
+	}
+}
+
+func TestReconcileKubeletService(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "web1.site-a",
+		UID:    "uid",
+		Labels: map[string]string{"allspark.sh/tenant": "acme"},
+	}}
+	desired := newKubeletService("kubelet-web1", node)
+	tests := []struct {
+		name        string
+		edit        func(svc *v1.Service)
+		wantChanged bool
+	}{
+		{"up to date", func(svc *v1.Service) {}, false},
+		{"defaulted node port", func(svc *v1.Service) { svc.Spec.Ports[0].NodePort = 30250 }, false},
+		{"extra label", func(svc *v1.Service) { svc.Labels["team"] = "infra" }, false},
+		{"moved tenant", func(svc *v1.Service) { svc.Spec.Selector = map[string]string{"tenant": "globex"} }, true},
+		{"edited port", func(svc *v1.Service) { svc.Spec.Ports[0].Port = 10255 }, true},
+		{"extra port", func(svc *v1.Service) { svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: 22}) }, true},
+		{"removed labels", func(svc *v1.Service) { svc.Labels = nil }, true},
+	}
+	for _, tt := range tests {
+		svc := desired.DeepCopy()
+		tt.edit(svc)
+		updated, changed := reconcileKubeletService(svc, desired)
+		if changed != tt.wantChanged {
+			t.Errorf("%s: expected changed=%v, got %v", tt.name, tt.wantChanged, changed)
+		}
+		if !reflect.DeepEqual(updated.Spec.Selector, desired.Spec.Selector) ||
+			!equalServicePorts(updated.Spec.Ports, desired.Spec.Ports) ||
+			updated.Labels["tenant"] != "acme" || updated.Labels["app"] != "kubelet" {
+			t.Errorf("%s: expected the service to match the desired one, got %#v", tt.name, updated)
+		}
+	}
+}
+
+func TestEnqueueServiceNode(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "web1.site-a",
+		UID:    "uid",
+		Labels: map[string]string{"allspark.sh/tenant": "acme"},
+	}}
+	kubelet := newKubeletService("kubelet-web1", node)
+	tests := []struct {
+		name string
+		obj  interface{}
+		want int
+	}{
+		{"kubelet service", kubelet, 1},
+		{"deleted kubelet service", cache.DeletedFinalStateUnknown{Key: "allspark/kubelet-web1", Obj: kubelet}, 1},
+		{"service without owner", &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "allspark"}}, 0},
+	}
+	for _, tt := range tests {
+		ctrl := &ASController{nodeQueue: NewTaskQueue("node-operator", func(string) error { return nil })}
+		ctrl.enqueueServiceNode(tt.obj)
+		if got := ctrl.nodeQueue.Len(); got != tt.want {
+			t.Errorf("%s: expected %d node(s) requeued, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDeleteStaleKubeletServices(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "web1.site-a",
+		UID:    "uid",
+		Labels: map[string]string{"allspark.sh/tenant": "acme"},
+	}}
+	current := newKubeletService(KubeletServiceName(node.Name), node)
+	// the legacy service still selects the previous tenant
+	legacy := newKubeletService("web1", node)
+	legacy.Spec.Selector = map[string]string{"tenant": "globex"}
+	stale := newKubeletService("kubelet-web1", node)
+	foreign := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web1-db", Namespace: "allspark"}}
+
+	for _, dnsConfigMap := range []string{"", "allspark-hosts"} {
+		var requests []string
+		apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+path.Base(r.URL.Path))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(legacy)
+		}))
+		ctrl := &ASController{
+			kubecli:       kubernetes.NewForConfigOrDie(&rest.Config{Host: apiServer.URL}),
+			ServiceLister: corelister.NewServiceLister(newIndexer(current, legacy, stale, foreign)),
+			cfg:           &conf.Config{DNSConfigMap: dnsConfigMap},
+		}
+		if err := ctrl.deleteStaleKubeletServices(node, current.Name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		apiServer.Close()
+		sort.Strings(requests)
+		want := []string{"DELETE kubelet-web1", "DELETE web1"}
+		if dnsConfigMap == "" {
+			// the legacy service is kept and follows the tenant of the node
+			want = []string{"DELETE kubelet-web1", "PUT web1"}
+		}
+		if !reflect.DeepEqual(requests, want) {
+			t.Errorf("dns config map %q: expected %v, got %v", dnsConfigMap, want, requests)
+		}
+	}
+}
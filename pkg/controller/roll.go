@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// imageRollTimeout lets the next pod roll when the
+// replacement of the current one never becomes ready
+const imageRollTimeout = 5 * time.Minute
+
+// imageRoll replaces the frps and frpc pods running an outdated image one at a
+// time, a reloaded image would otherwise delete every pod in the next resync
+type imageRoll struct {
+	mu sync.Mutex
+	// namespace/name of the pod being replaced, empty when none is
+	pod     string
+	started time.Time
+	// keys waiting for the current roll to finish mapped to their queue
+	waiting map[string]*TaskQueue
+}
+
+func newImageRoll() *imageRoll {
+	return &imageRoll{waiting: map[string]*TaskQueue{}}
+}
+
+// acquire returns true when the pod may be deleted, otherwise the
+// key is added to the queue again once the current roll finishes
+func (r *imageRoll) acquire(pod string, queue *TaskQueue, key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pod != "" && r.pod != pod {
+		if time.Since(r.started) < imageRollTimeout {
+			r.waiting[key] = queue
+			return false
+		}
+		glog.Warningf("Pod %q isn't ready after %v, rolling pod %q", r.pod, imageRollTimeout, pod)
+	}
+	if r.pod != pod {
+		r.pod, r.started = pod, time.Now()
+	}
+	return true
+}
+
+// release finishes the roll of the pod when it's the current one,
+// it's called once the replaced pod is running the desired image
+func (r *imageRoll) release(pod string) {
+	r.mu.Lock()
+	if r.pod != pod {
+		r.mu.Unlock()
+		return
+	}
+	waiting := r.waiting
+	r.pod, r.waiting = "", map[string]*TaskQueue{}
+	r.mu.Unlock()
+	for key, queue := range waiting {
+		queue.AddKey(key)
+	}
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/conf"
+	"github.com/sparkcorp/allspark/pkg/version"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// ReasonUnsupportedVersion is the reason of the Events warning about a version skew
+	ReasonUnsupportedVersion = "UnsupportedVersion"
+	// syncerVersionTTL forgets the syncers which stopped polling the ini-server
+	syncerVersionTTL = 15 * time.Minute
+
+	ComponentSyncer = "ini-sync"
+	ComponentFRPC   = "frpc"
+	ComponentFRPS   = "frps"
+)
+
+// SyncerVersion is the version reported by an ini-sync, it syncs
+// the frpc of an Ingress or of a Node (kubelet)
+type SyncerVersion struct {
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	FRPVersion string    `json:"frpVersion,omitempty"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+func (s *SyncerVersion) key() string {
+	return fmt.Sprintf("%s/%s/%s", s.Kind, s.Namespace, s.Name)
+}
+
+// versionSkew keeps the versions of the components connected to the controller
+type versionSkew struct {
+	// nil ranges allow any version
+	syncerRange *version.Range
+	frpRange    *version.Range
+
+	mu      sync.Mutex
+	syncers map[string]*SyncerVersion
+	// syncers not seen within syncerVersionTTL are pruned at most once a minute
+	lastPrune time.Time
+	// last frps version checked of each tenant
+	frps map[string]string
+}
+
+// newVersionSkew parses the ranges of the config, they're validated when it's loaded
+func newVersionSkew(cfg *conf.Config) *versionSkew {
+	s := &versionSkew{syncers: map[string]*SyncerVersion{}, frps: map[string]string{}}
+	if cfg.SyncerVersionRange == "" {
+		s.syncerRange = version.SyncerRange(version.Get().Version)
+	} else {
+		s.syncerRange, _ = version.ParseRange(cfg.SyncerVersionRange)
+	}
+	s.frpRange, _ = version.ParseRange(cfg.FRPVersionRange)
+	return s
+}
+
+// supported checks a version against a range, versions which aren't
+// releases (e.g.: development builds) can't be checked and are allowed
+func supported(r *version.Range, v string) bool {
+	if r == nil {
+		return true
+	}
+	semver, err := version.ParseSemver(v)
+	if err != nil {
+		glog.V(4).Infof("skipping version check of %q: %v", v, err)
+		return true
+	}
+	return r.Contains(semver)
+}
+
+// SupportedVersions returns the ranges of ini-sync and frp versions
+// supported by the controller, empty if any version is allowed
+func (c *ASController) SupportedVersions() (syncer, frp string) {
+	if c.skew.syncerRange != nil {
+		syncer = c.skew.syncerRange.String()
+	}
+	return syncer, c.skew.frpRange.String()
+}
+
+// RecordSyncer records the version reported by an ini-sync, a warning is
+// emitted the first time an unsupported version is seen. The kind and name
+// come from the request, syncers of objects that don't exist aren't recorded.
+func (c *ASController) RecordSyncer(sv SyncerVersion) {
+	obj := c.syncerObject(&sv)
+	if obj == nil {
+		glog.V(4).Infof("skipping version of ini-sync, %s %s not found", sv.Kind, sv.key())
+		return
+	}
+	sv.LastSeen = time.Now().UTC()
+	c.skew.mu.Lock()
+	if sv.LastSeen.Sub(c.skew.lastPrune) > time.Minute {
+		c.skew.pruneSyncers()
+		c.skew.lastPrune = sv.LastSeen
+	}
+	prev, ok := c.skew.syncers[sv.key()]
+	c.skew.syncers[sv.key()] = &sv
+	c.skew.mu.Unlock()
+	if ok && prev.Version == sv.Version && prev.FRPVersion == sv.FRPVersion {
+		return
+	}
+	glog.V(2).Infof("ini-sync of %s %s reported version %s (frp %s)", sv.Kind, sv.key(), sv.Version, sv.FRPVersion)
+	if !supported(c.skew.syncerRange, sv.Version) {
+		c.warnVersion(obj, "ini-sync %s of %s %q is outside the supported range %q of the controller",
+			sv.Version, sv.Kind, sv.Name, c.skew.syncerRange.String())
+	}
+	if sv.FRPVersion != "" && !supported(c.skew.frpRange, sv.FRPVersion) {
+		c.warnVersion(obj, "frpc %s of %s %q is outside the supported range %q of the controller",
+			sv.FRPVersion, sv.Kind, sv.Name, c.skew.frpRange.String())
+	}
+}
+
+// SyncerVersions returns the syncers seen recently, sorted by kind and name
+func (c *ASController) SyncerVersions() []SyncerVersion {
+	c.skew.mu.Lock()
+	defer c.skew.mu.Unlock()
+	c.skew.pruneSyncers()
+	var syncers []SyncerVersion
+	for _, sv := range c.skew.syncers {
+		syncers = append(syncers, *sv)
+	}
+	sort.Slice(syncers, func(i, j int) bool { return syncers[i].key() < syncers[j].key() })
+	return syncers
+}
+
+// pruneSyncers forgets the syncers not seen within syncerVersionTTL, the lock must be held
+func (s *versionSkew) pruneSyncers() {
+	for key, sv := range s.syncers {
+		if time.Since(sv.LastSeen) > syncerVersionTTL {
+			delete(s.syncers, key)
+		}
+	}
+}
+
+// checkFRPSVersion warns when the frps of a tenant runs an unsupported version
+func (c *ASController) checkFRPSVersion(tenant, frpsVersion string) {
+	c.skew.mu.Lock()
+	prev := c.skew.frps[tenant]
+	c.skew.frps[tenant] = frpsVersion
+	c.skew.mu.Unlock()
+	if prev == frpsVersion || supported(c.skew.frpRange, frpsVersion) {
+		return
+	}
+	var obj runtime.Object
+	if pod, err := c.SystemPodLister.Pods(os.Getenv("POD_NAMESPACE")).Get(tenant); err == nil {
+		obj = pod
+	}
+	c.warnVersion(obj, "frps %s of tenant %q is outside the supported range %q of the controller",
+		frpsVersion, tenant, c.skew.frpRange.String())
+}
+
+// componentVersions counts the versions of each component and if they're supported
+func (c *ASController) componentVersions() map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	add := func(component, v string) {
+		if v == "" {
+			v = "unknown"
+		}
+		if counts[component] == nil {
+			counts[component] = map[string]int{}
+		}
+		counts[component][v]++
+	}
+	for _, sv := range c.SyncerVersions() {
+		add(ComponentSyncer, sv.Version)
+		if sv.FRPVersion != "" {
+			add(ComponentFRPC, sv.FRPVersion)
+		}
+	}
+	c.statusMu.RLock()
+	for _, status := range c.tenantStatus {
+		if status.Online {
+			add(ComponentFRPS, status.Version)
+		}
+	}
+	c.statusMu.RUnlock()
+	return counts
+}
+
+// versionSupported checks the version of a component against its range
+func (c *ASController) versionSupported(component, v string) bool {
+	if component == ComponentSyncer {
+		return supported(c.skew.syncerRange, v)
+	}
+	return supported(c.skew.frpRange, v)
+}
+
+// syncerObject returns the object synced by an ini-sync, nil if it no longer exists
+func (c *ASController) syncerObject(sv *SyncerVersion) runtime.Object {
+	switch sv.Kind {
+	case "Ingress":
+		if ing, err := c.IngressLister.Ingresses(sv.Namespace).Get(sv.Name); err == nil {
+			return ing
+		}
+	case "Node":
+		if node, err := c.NodeLister.Get(sv.Name); err == nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// warnVersion logs a version skew and records it as an Event of the object
+func (c *ASController) warnVersion(obj runtime.Object, msg string, a ...interface{}) {
+	glog.Warningf(msg, a...)
+	if obj != nil {
+		c.recorder.Eventf(obj, v1.EventTypeWarning, ReasonUnsupportedVersion, msg, a...)
+	}
+}
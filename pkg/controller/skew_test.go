@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	extlister "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+)
+
+// newSkewController caches the nodes edge-1 and edge-2 and the ingress acme/web
+func newSkewController(cfg *conf.Config) (*ASController, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &ASController{
+		IngressLister: extlister.NewIngressLister(newIndexer(
+			&extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "acme"}},
+		)),
+		NodeLister: corelister.NewNodeLister(newIndexer(
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-1"}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-2"}},
+		)),
+		SystemPodLister: corelister.NewPodLister(newIndexer()),
+		recorder:        recorder,
+		skew:            newVersionSkew(cfg),
+	}, recorder
+}
+
+func TestRecordSyncer(t *testing.T) {
+	c, recorder := newSkewController(&conf.Config{
+		SyncerVersionRange: ">=0.2.0",
+		FRPVersionRange:    ">=0.20.0 <0.22.0",
+	})
+
+	c.RecordSyncer(SyncerVersion{Kind: "Node", Name: "edge-1", Version: "v0.1.0", FRPVersion: "0.21.0"})
+	c.RecordSyncer(SyncerVersion{Kind: "Ingress", Namespace: "acme", Name: "web", Version: "v0.2.0", FRPVersion: "0.22.0"})
+	// the same version doesn't warn again
+	c.RecordSyncer(SyncerVersion{Kind: "Node", Name: "edge-1", Version: "v0.1.0", FRPVersion: "0.21.0"})
+	// syncers of objects that don't exist aren't recorded
+	c.RecordSyncer(SyncerVersion{Kind: "Ingress", Namespace: "acme", Name: "missing", Version: "v0.1.0"})
+
+	for _, want := range []string{"ini-sync v0.1.0", "frpc 0.22.0"} {
+		select {
+		case event := <-recorder.Events:
+			if !strings.Contains(event, ReasonUnsupportedVersion) || !strings.Contains(event, want) {
+				t.Errorf("expected an event for the unsupported %s, got %s", want, event)
+			}
+		default:
+			t.Fatalf("expected an event for the unsupported %s", want)
+		}
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event: %s", event)
+	default:
+	}
+
+	syncers := c.SyncerVersions()
+	if len(syncers) != 2 || syncers[0].Kind != "Ingress" || syncers[1].Kind != "Node" {
+		t.Fatalf("expected syncers sorted by kind, got %+v", syncers)
+	}
+	c.skew.syncers["Node//edge-1"].LastSeen = time.Now().Add(-syncerVersionTTL - time.Minute)
+	if syncers := c.SyncerVersions(); len(syncers) != 1 || syncers[0].Name != "web" {
+		t.Errorf("expected the stale syncer to be forgotten, got %+v", syncers)
+	}
+}
+
+func TestComponentVersions(t *testing.T) {
+	c, _ := newSkewController(&conf.Config{SyncerVersionRange: ">=0.2.0", FRPVersionRange: ">=0.20.0"})
+	c.RecordSyncer(SyncerVersion{Kind: "Node", Name: "edge-1", Version: "v0.2.0", FRPVersion: "0.21.0"})
+	c.RecordSyncer(SyncerVersion{Kind: "Node", Name: "edge-2", Version: "v0.1.0"})
+	c.tenantStatus = map[string]*api.TenantStatus{
+		"acme":   {Online: true, Version: "0.19.0"},
+		"globex": {Online: true},
+	}
+
+	counts := c.componentVersions()
+	for _, tt := range []struct {
+		component, version string
+		count              int
+		supported          bool
+	}{
+		{ComponentSyncer, "v0.2.0", 1, true},
+		{ComponentSyncer, "v0.1.0", 1, false},
+		{ComponentFRPC, "0.21.0", 1, true},
+		{ComponentFRPS, "0.19.0", 1, false},
+		{ComponentFRPS, "unknown", 1, true},
+	} {
+		if got := counts[tt.component][tt.version]; got != tt.count {
+			t.Errorf("expected %d %s %s, got %d", tt.count, tt.component, tt.version, got)
+		}
+		if got := c.versionSupported(tt.component, tt.version); got != tt.supported {
+			t.Errorf("expected %s %s supported=%v, got %v", tt.component, tt.version, tt.supported, got)
+		}
+	}
+}
+
+func TestCheckFRPSVersion(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	c, recorder := newSkewController(&conf.Config{FRPVersionRange: ">=0.20.0"})
+	c.SystemPodLister = corelister.NewPodLister(newIndexer(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "allspark"}},
+	))
+	c.checkFRPSVersion("acme", "0.19.0")
+	c.checkFRPSVersion("acme", "0.19.0")
+	c.checkFRPSVersion("acme", "0.21.0")
+	if n := len(recorder.Events); n != 1 {
+		t.Errorf("expected a single event, got %d", n)
+	}
+}
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/metrics"
 	extensions "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -39,6 +42,27 @@ func isFrpIngress(ing *extensions.Ingress) bool {
 	return class == "" || class == frpIngressClass
 }
 
+// RuleHost returns the host of an ingress rule and its frp subdomain when the
+// host belongs to the tenant domain. Rules without host are exposed under the
+// tenant domain, ok is false if the rule can't be exposed.
+func RuleHost(ing *extensions.Ingress, r extensions.IngressRule, subdomainHost string) (host, subdomain string, ok bool) {
+	host = r.Host
+	switch {
+	case host == "" && subdomainHost != "":
+		subdomain = fmt.Sprintf("%s-%s", ing.Name, ing.Namespace)
+		host = fmt.Sprintf("%s.%s", subdomain, subdomainHost)
+	case subdomainHost != "" && strings.HasSuffix(host, "."+subdomainHost):
+		subdomain = strings.TrimSuffix(host, "."+subdomainHost)
+		// frp doesn't support dots on subdomains
+		if strings.Contains(subdomain, ".") {
+			return "", "", false
+		}
+	case host == "":
+		return "", "", false
+	}
+	return host, subdomain, true
+}
+
 // isAllSparkResource returns true if the given namespace has a specific Label
 func isAllSparkResource(meta metav1.Object) bool {
 	labels := meta.GetLabels()
@@ -54,12 +78,12 @@ func isAllSparkResource(meta metav1.Object) bool {
 // TaskQueue manages a work queue through an independent worker that
 // invokes the given sync function for every work item inserted.
 type TaskQueue struct {
+	// name identifies the queue in logs and metrics
+	name string
 	// queue is the work queue the worker polls
 	queue workqueue.RateLimitingInterface
 	// sync is called for each item in the queue
 	sync func(string) error
-	// workerDone is closed when the worker exits
-	workerDone chan struct{}
 }
 
 func (t *TaskQueue) run(period time.Duration, stopCh <-chan struct{}) {
@@ -69,6 +93,11 @@ func (t *TaskQueue) run(period time.Duration, stopCh <-chan struct{}) {
 // Len retrieves the lenght of the queue
 func (t *TaskQueue) Len() int { return t.queue.Len() }
 
+// AddKey enqueues a key in the task queue.
+func (t *TaskQueue) AddKey(key string) {
+	t.queue.Add(key)
+}
+
 // Add enqueues ns/name of the given api object in the task queue.
 func (t *TaskQueue) Add(obj interface{}) {
 	key, err := KeyFunc(obj)
@@ -80,37 +109,38 @@ func (t *TaskQueue) Add(obj interface{}) {
 }
 
 func (t *TaskQueue) runWorker() {
-	for {
-		// hot loop until we're told to stop.  processNextWorkItem will automatically
-		// wait until there's work available, so we don't worry about secondary waits
-		t.processNextWorkItem()
+	// hot loop until we're told to stop.  processNextWorkItem will automatically
+	// wait until there's work available, so we don't worry about secondary waits
+	for t.processNextWorkItem() {
 	}
 }
 
-// worker processes work in the queue through sync.
-func (t *TaskQueue) processNextWorkItem() {
+// worker processes work in the queue through sync, returns false
+// when the queue is shutting down
+func (t *TaskQueue) processNextWorkItem() bool {
 	key, quit := t.queue.Get()
 	if quit {
-		close(t.workerDone)
-		return
+		return false
 	}
 	if key == nil {
-		return
+		return true
 	}
+	defer t.queue.Done(key)
 	glog.V(2).Infof("Syncing %v", key)
 	if err := t.sync(key.(string)); err != nil {
 		glog.V(2).Infof("Requeuing %v, err: %v", key, err)
+		metrics.ReconcileErrors.WithLabelValues(t.name).Inc()
 		t.queue.AddRateLimited(key)
 	} else {
 		t.queue.Forget(key)
 	}
-	t.queue.Done(key)
+	return true
 }
 
-// shutdown shuts down the work queue and waits for the worker to ACK
+// Shutdown stops accepting items, the workers exit once the items
+// already queued are synced
 func (t *TaskQueue) Shutdown() {
 	t.queue.ShutDown()
-	<-t.workerDone
 }
 
 // NewTaskQueue creates a new task queue with the given sync function.
@@ -121,8 +151,8 @@ func NewTaskQueue(queueName string, syncFn func(string) error) *TaskQueue {
 		queueName,
 	)
 	return &TaskQueue{
-		queue:      rateLimitQueue,
-		sync:       syncFn,
-		workerDone: make(chan struct{}),
+		name:  queueName,
+		queue: rateLimitQueue,
+		sync:  syncFn,
 	}
 }
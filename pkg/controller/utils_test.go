@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTaskQueueShutdownDrainsQueue(t *testing.T) {
+	syncing, release := make(chan string), make(chan struct{})
+	queue := NewTaskQueue("test", func(key string) error {
+		syncing <- key
+		<-release
+		return nil
+	})
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		queue.run(time.Second, stopCh)
+		close(done)
+	}()
+	queue.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme"}})
+	if key := <-syncing; key != "acme" {
+		t.Fatalf("expected to sync acme, got %q", key)
+	}
+	queue.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "globex"}})
+
+	// as on Run, the shutdown doesn't block and the worker exits once the queue is drained
+	close(stopCh)
+	queue.Shutdown()
+	select {
+	case <-done:
+		t.Fatalf("expected the worker to wait for the in-flight sync")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if key := <-syncing; key != "globex" {
+		t.Fatalf("expected the queued item to be synced, got %q", key)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the worker to exit")
+	}
+}
+
+func TestRuleHost(t *testing.T) {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "office"}}
+	tests := []struct {
+		name          string
+		host          string
+		subdomainHost string
+		wantHost      string
+		wantSubdomain string
+		wantOK        bool
+	}{
+		{"no host on tenant domain", "", "acme.allspark.sh", "web-office.acme.allspark.sh", "web-office", true},
+		{"no host without tenant domain", "", "", "", "", false},
+		{"host on tenant domain", "app.acme.allspark.sh", "acme.allspark.sh", "app.acme.allspark.sh", "app", true},
+		{"nested host on tenant domain", "a.b.acme.allspark.sh", "acme.allspark.sh", "", "", false},
+		{"custom domain", "www.example.com", "acme.allspark.sh", "www.example.com", "", true},
+		{"custom domain without tenant domain", "www.example.com", "", "www.example.com", "", true},
+		{"suffix without dot isn't the tenant domain", "myacme.allspark.sh", "acme.allspark.sh", "myacme.allspark.sh", "", true},
+	}
+	for _, tt := range tests {
+		rule := extensions.IngressRule{Host: tt.host}
+		host, subdomain, ok := RuleHost(ing, rule, tt.subdomainHost)
+		if host != tt.wantHost || subdomain != tt.wantSubdomain || ok != tt.wantOK {
+			t.Errorf("%s: RuleHost(%q, %q) = %q, %q, %v, want %q, %q, %v", tt.name, tt.host, tt.subdomainHost,
+				host, subdomain, ok, tt.wantHost, tt.wantSubdomain, tt.wantOK)
+		}
+	}
+}
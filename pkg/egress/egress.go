@@ -0,0 +1,109 @@
+package egress
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+// Resolver finds the tunnel address of a kubelet
+type Resolver interface {
+	// KubeletTunnelAddress returns the address of the tunnel of the kubelet
+	// listening on hostport, the host is the node name or one of its addresses
+	KubeletTunnelAddress(hostport string) (string, error)
+}
+
+// Proxy is an HTTP CONNECT proxy compatible with the egress selector of the
+// API Server, it routes the connections of each kubelet through its tunnel.
+type Proxy struct {
+	resolver    Resolver
+	dialTimeout time.Duration
+}
+
+func New(resolver Resolver) *Proxy {
+	return &Proxy{resolver: resolver, dialTimeout: defaultDialTimeout}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		httputil.HttpError(http.StatusMethodNotAllowed, httputil.ReasonMethodNotAllowed).
+			MessageF("Only CONNECT requests are supported").
+			Write(w)
+		return
+	}
+	addr, err := p.resolver.KubeletTunnelAddress(r.Host)
+	if err != nil {
+		glog.Warningf("egress - failed resolving %q: %v", r.Host, err)
+		httputil.HttpError(http.StatusBadGateway, httputil.ReasonKubeletNotFound).
+			MessageF("Failed resolving the tunnel of %q: %v", r.Host, err).
+			Write(w)
+		return
+	}
+	backend, err := net.DialTimeout("tcp", addr, p.dialTimeout)
+	if err != nil {
+		glog.Warningf("egress - failed dialing %q (%s): %v", r.Host, addr, err)
+		httputil.HttpError(http.StatusBadGateway, httputil.ReasonTunnelUnreachable).
+			MessageF("Failed dialing the tunnel of %q: %v", r.Host, err).
+			Write(w)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		backend.Close()
+		httputil.HttpError(http.StatusInternalServerError, httputil.ReasonHijackNotSupported).
+			MessageF("The connection doesn't support hijacking").
+			Write(w)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		backend.Close()
+		glog.Errorf("egress - failed hijacking connection: %v", err)
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		backend.Close()
+		return
+	}
+	// data sent by the client before the response was buffered by the server
+	if n := buf.Reader.Buffered(); n > 0 {
+		data, _ := buf.Reader.Peek(n)
+		if _, err := backend.Write(data); err != nil {
+			conn.Close()
+			backend.Close()
+			return
+		}
+	}
+	glog.V(2).Infof("egress - tunneling %s to %s (%s)", r.RemoteAddr, r.Host, addr)
+	pipe(conn, backend)
+}
+
+// pipe copies data in both directions until one of the sides is closed
+func pipe(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		a.Close()
+		b.Close()
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+		once.Do(closeBoth)
+	}()
+	wg.Wait()
+}
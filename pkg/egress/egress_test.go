@@ -0,0 +1,145 @@
+package egress
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
+)
+
+type fakeResolver map[string]string
+
+func (r fakeResolver) KubeletTunnelAddress(hostport string) (string, error) {
+	addr, ok := r[hostport]
+	if !ok {
+		return "", fmt.Errorf("node %q not found", hostport)
+	}
+	return addr, nil
+}
+
+// echoServer accepts connections and writes back everything it reads
+func echoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+// connect sends a CONNECT request to the proxy and returns the connection and its response
+func connect(t *testing.T, proxyAddr, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("failed reading the CONNECT response: %v", err)
+	}
+	return conn, br, resp
+}
+
+func TestProxyConnect(t *testing.T) {
+	tunnel := echoServer(t)
+	defer tunnel.Close()
+	proxy := httptest.NewServer(New(fakeResolver{"web1.site-a:10250": tunnel.Addr().String()}))
+	defer proxy.Close()
+
+	conn, br, resp := connect(t, proxy.Listener.Addr().String(), "web1.site-a:10250")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the connection to be established, got %s", resp.Status)
+	}
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed reading from the tunnel: %v", err)
+	}
+	if line != "ping\n" {
+		t.Errorf("expected the data to be echoed by the tunnel, got %q", line)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	// nothing listens on the tunnel of web2
+	closed := echoServer(t)
+	closed.Close()
+	proxy := httptest.NewServer(New(fakeResolver{"web2.site-a:10250": closed.Addr().String()}))
+	defer proxy.Close()
+
+	for _, tt := range []struct {
+		target, reason string
+	}{
+		{"web3.site-a:10250", "KubeletNotFound"},
+		{"web2.site-a:10250", "TunnelUnreachable"},
+	} {
+		conn, _, resp := connect(t, proxy.Listener.Addr().String(), tt.target)
+		var apiErr httputil.ApiError
+		err := json.NewDecoder(resp.Body).Decode(&apiErr)
+		conn.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("%s: expected status 502, got %s", tt.target, resp.Status)
+		}
+		if err != nil || apiErr.Reason != tt.reason {
+			t.Errorf("%s: expected the reason %q, got %#v (%v)", tt.target, tt.reason, apiErr, err)
+		}
+	}
+
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected only CONNECT requests to be allowed, got %s", resp.Status)
+	}
+}
+
+func TestListen(t *testing.T) {
+	if _, err := Listen(Config{Address: "127.0.0.1:0"}); err == nil {
+		t.Errorf("expected tcp listeners without mutual tls to be refused")
+	}
+	dir, err := ioutil.TempDir("", "egress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "egress.sock")
+	// a stale socket of a previous process is replaced
+	if err := ioutil.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen(Config{UDSName: socket})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	if l.Addr().Network() != "unix" {
+		t.Errorf("expected a unix listener, got %s", l.Addr().Network())
+	}
+}
@@ -0,0 +1,70 @@
+package egress
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
+)
+
+// Config configures the listener of the egress proxy, it listens on a
+// unix socket when UDSName is set, otherwise on a tcp Address
+type Config struct {
+	Address string
+	UDSName string
+	// mutual TLS is required when listening on tcp
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Listen creates the listener of the egress proxy
+func Listen(cfg Config) (net.Listener, error) {
+	if cfg.UDSName != "" {
+		// remove a stale socket left by a previous process
+		if err := os.Remove(cfg.UDSName); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed removing socket %q: %v", cfg.UDSName, err)
+		}
+		return net.Listen("unix", cfg.UDSName)
+	}
+	// clients must be authenticated, the proxy dials every kubelet
+	if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("the egress proxy requires a certificate, a key and a client CA on tcp")
+	}
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, tlsConfig), nil
+}
+
+func serverTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig, err := httputil.ServerTLSConfig(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed configuring egress tls: %v", err)
+	}
+	// CONNECT can't be hijacked over http2
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	return tlsConfig, nil
+}
+
+// NewServer creates the http server of the egress proxy, it must
+// serve a listener created by Listen
+func NewServer(resolver Resolver) *http.Server {
+	return &http.Server{
+		Handler: httputil.Chain(New(resolver),
+			httputil.RequestID,
+			httputil.AccessLog,
+			httputil.Recover,
+		),
+		// disable http2, CONNECT requests must be hijacked
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/controller"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/version"
+)
+
+// apiVersions is the discovery document of the ini-server
+var apiVersions = api.APIVersions{
+	Versions:         []string{api.IniServerV1, api.IniServerV2},
+	PreferredVersion: api.IniServerV2,
+	Capabilities:     []string{api.CapabilityWatch, api.CapabilityETag},
+}
+
+// Register adds the routes of all versions of the ini-server api, the
+// routes are the operations of api.IniServerOperations
+func (h *Handler) Register(r *mux.Router) {
+	for _, op := range api.IniServerOperations {
+		handler, ok := h.operations()[op.ID]
+		if !ok {
+			panic(fmt.Sprintf("ini-server operation %q doesn't have a handler", op.ID))
+		}
+		r.HandleFunc(op.Path, handler).Methods(op.Method)
+	}
+}
+
+// operations maps the ids of api.IniServerOperations to their handlers
+func (h *Handler) operations() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		api.OpGetAPIVersions: h.APIVersions,
+		api.OpGetOpenAPI:     h.OpenAPI,
+		api.OpGetVersion:     h.Version,
+		// v1 is kept unchanged for older ini-sync binaries
+		api.OpGetIngressIniV1:    h.IngressToIni,
+		api.OpWatchIngressV1:     h.WatchIni,
+		api.OpGetIngressConfig:   h.IngressConfig,
+		api.OpWatchIngressConfig: h.WatchIni,
+	}
+}
+
+// APIVersions serves the versions and capabilities of the ini-server
+func (h *Handler) APIVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &apiVersions)
+}
+
+// OpenAPI serves the OpenAPI document of the ini-server
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := api.OpenAPIDocument()
+	if err != nil {
+		httputil.HttpError(500, httputil.ReasonInternalError).
+			MessageF("Failed rendering openapi document: %v", err).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(doc); err != nil {
+		glog.Errorf("failed writing openapi document: %v", err)
+	}
+}
+
+// Version serves the version of the controller, kubelet ini-syncs report
+// their version to it since they don't fetch their ini from the ini-server
+func (h *Handler) Version(w http.ResponseWriter, r *http.Request) {
+	if node := r.Header.Get(version.NodeHeader); node != "" {
+		h.recordSyncer(r, "Node", "", node)
+	}
+	syncerRange, frpRange := h.ctrl.SupportedVersions()
+	writeJSON(w, &api.ServerVersion{
+		Info:               version.Get(),
+		SyncerVersionRange: syncerRange,
+		FRPVersionRange:    frpRange,
+	})
+}
+
+// recordSyncer records the version of the ini-sync of an object, older
+// binaries don't send it and aren't recorded
+func (h *Handler) recordSyncer(r *http.Request, kind, namespace, name string) {
+	v := r.Header.Get(version.Header)
+	if v == "" {
+		return
+	}
+	h.ctrl.RecordSyncer(controller.SyncerVersion{
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+		Version:    v,
+		FRPVersion: r.Header.Get(version.FRPHeader),
+	})
+}
+
+// IngressConfig serves the frpc config of an ingress (v2)
+func (h *Handler) IngressConfig(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	namespace, ingressName := params["namespace"], params["name"]
+	h.recordSyncer(r, "Ingress", namespace, ingressName)
+	data, hash, apiErr := h.renderConfig(namespace, ingressName)
+	if apiErr != nil {
+		apiErr.Write(w)
+		return
+	}
+	if notModified(w, r, hash) {
+		return
+	}
+	writeJSON(w, &api.IngressConfig{
+		APIVersion: api.IniServerV2,
+		Kind:       "IngressConfig",
+		Namespace:  namespace,
+		Name:       ingressName,
+		Hash:       hash,
+		Ini:        string(data),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		glog.Errorf("failed encoding response: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+	"github.com/sparkcorp/allspark/pkg/version"
+)
+
+func TestAPIVersions(t *testing.T) {
+	router := newTestRouter(&conf.Config{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/apis", nil))
+	var versions api.APIVersions
+	if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil {
+		t.Fatalf("failed decoding versions: %v", err)
+	}
+	if !reflect.DeepEqual(versions, apiVersions) {
+		t.Errorf("expected %#v, got %#v", apiVersions, versions)
+	}
+	if !versions.Supports(api.IniServerV1) || !versions.HasCapability(api.CapabilityWatch) {
+		t.Errorf("expected v1 and watch to be advertised, got %#v", versions)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	router := newTestRouter(&conf.Config{SyncerVersionRange: ">=0.2.0", FRPVersionRange: ">=0.20.0 <0.22.0"})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/version", nil)
+	req.Header.Set(version.Header, "v0.2.0")
+	req.Header.Set(version.NodeHeader, "edge-1")
+	router.ServeHTTP(w, req)
+	var v api.ServerVersion
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("failed decoding version: %v", err)
+	}
+	if v.Version != version.Get().Version {
+		t.Errorf("expected version %q, got %q", version.Get().Version, v.Version)
+	}
+	if v.SyncerVersionRange != ">=0.2.0" || v.FRPVersionRange != ">=0.20.0 <0.22.0" {
+		t.Errorf("expected the supported ranges of the config, got %q and %q", v.SyncerVersionRange, v.FRPVersionRange)
+	}
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	router := newTestRouter(&conf.Config{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed decoding the openapi document: %v", err)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatalf("expected the paths of the api")
+	}
+	// every operation of the document is routed
+	for path, methods := range doc.Paths {
+		url := strings.NewReplacer("{namespace}", "office", "{name}", "web").Replace(path)
+		for method, op := range methods {
+			r := httptest.NewRequest(strings.ToUpper(method), url, nil)
+			w := httptest.NewRecorder()
+			if strings.HasSuffix(path, "/watch") {
+				// the stream ends with the request
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			router.ServeHTTP(w, r)
+			if w.Code == http.StatusNotFound || w.Code == http.StatusMethodNotAllowed {
+				t.Errorf("%s %s (%s) isn't routed, got %d", method, path, op.OperationID, w.Code)
+			}
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	router := newTestRouter(&conf.Config{}).(*mux.Router)
+	var routes []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		routes = append(routes, strings.Join(methods, ",")+" "+path)
+		return nil
+	})
+	var want []string
+	for _, op := range api.IniServerOperations {
+		want = append(want, op.Method+" "+op.Path)
+	}
+	// a route for each operation, in the order of the table
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("expected the routes %v, got %v", want, routes)
+	}
+}
+
+func TestIngressConfig(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	router := newTestRouter(&conf.Config{}, tenantObjects(newIngress("office", "web", "app.example.com"))...)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/namespaces/office/ingresses/web", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var config api.IngressConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatalf("failed decoding config: %v", err)
+	}
+	if config.APIVersion != api.IniServerV2 || config.Kind != "IngressConfig" ||
+		config.Namespace != "office" || config.Name != "web" || !strings.Contains(config.Ini, "[common]") {
+		t.Errorf("unexpected config: %#v", config)
+	}
+	if etag := w.Header().Get("ETag"); etag != `W/"`+config.Hash+`"` {
+		t.Errorf("expected the hash as a weak etag, got %q and %q", etag, config.Hash)
+	}
+
+	// v1 serves the same ini
+	v1 := httptest.NewRecorder()
+	router.ServeHTTP(v1, httptest.NewRequest("GET", "/v1/namespaces/office/ingress/web", nil))
+	if v1.Body.String() != config.Ini || v1.Header().Get("ETag") != w.Header().Get("ETag") {
+		t.Errorf("expected v1 and v2 to serve the same ini")
+	}
+
+	r := httptest.NewRequest("GET", "/v2/namespaces/office/ingresses/web", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected an unchanged config to be answered with 304, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v2/namespaces/office/ingresses/web", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected only GET to be served, got %d", w.Code)
+	}
+}
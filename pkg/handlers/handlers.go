@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -23,70 +26,145 @@ type Handler struct {
 	common      *api.FrpcCommon
 	frpsAddress string
 	frpsPort    int32
+	watchers    *broadcaster
+	closeOnce   sync.Once
+	done        chan struct{}
+
+	// open watches of each client, see LimitWatches
+	clientIP   httputil.ClientIPFunc
+	maxWatches int
+	watchesMu  sync.Mutex
+	watches    map[string]int
 }
 
 func New(ctrl *controller.ASController, common *api.FrpcCommon) *Handler {
-	return &Handler{ctrl: ctrl, common: common}
+	return &Handler{ctrl: ctrl, common: common, watchers: newBroadcaster(), done: make(chan struct{})}
+}
+
+// LimitWatches bounds the watches open at once by each client, zero disables the limit
+func (h *Handler) LimitWatches(clientIP httputil.ClientIPFunc, max int) {
+	h.clientIP, h.maxWatches, h.watches = clientIP, max, map[string]int{}
+}
+
+// CloseWatches ends all open watches, e.g.: when shutting down the server
+func (h *Handler) CloseWatches() {
+	h.closeOnce.Do(func() { close(h.done) })
 }
 
+// IngressToIni serves the ini of an ingress (v1)
 func (h *Handler) IngressToIni(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	namespace, ingressName := params["namespace"], params["name"]
+	h.recordSyncer(r, "Ingress", params["namespace"], params["name"])
+	data, hash, apiErr := h.renderConfig(params["namespace"], params["name"])
+	if apiErr != nil {
+		apiErr.Write(w)
+		return
+	}
+	if notModified(w, r, hash) {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		glog.Errorf("failed writing ini file: %v", err)
+	}
+}
+
+// notModified sets the ETag of a config and answers with 304 when the
+// client already has it, the hash is the same id sent by the watch. The
+// ETag is weak since the ini (v1) and its json (v2) share the hash.
+func notModified(w http.ResponseWriter, r *http.Request, hash string) bool {
+	etag := fmt.Sprintf("%q", hash)
+	w.Header().Set("ETag", "W/"+etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatch checks an If-None-Match header against an etag, with the weak
+// comparison: the W/ prefix of the candidates is ignored
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// renderConfig renders the frpc ini of an ingress and its hash
+func (h *Handler) renderConfig(namespace, ingressName string) ([]byte, string, *httputil.ApiError) {
+	frpcini, apiErr := h.renderIni(namespace, ingressName)
+	if apiErr != nil {
+		return nil, "", apiErr
+	}
+	var buf bytes.Buffer
+	if _, err := frpcini.WriteTo(&buf); err != nil {
+		return nil, "", httputil.HttpError(500, httputil.ReasonInvalidSection).
+			MessageF("Failed rendering ini: %v", err)
+	}
+	return buf.Bytes(), controller.ConfigHash(buf.Bytes()), nil
+}
+
+// renderIni renders the frpc ini of an ingress
+func (h *Handler) renderIni(namespace, ingressName string) (*ini.File, *httputil.ApiError) {
 	ing, err := h.ctrl.IngressLister.Ingresses(namespace).Get(ingressName)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			httputil.HttpError(400, "IngressNotFound").
-				MessageF(MessageIngressNotFound, namespace, ingressName).
-				Write(w)
-
-			return
+			return nil, httputil.HttpError(400, httputil.ReasonIngressNotFound).
+				MessageF(MessageIngressNotFound, namespace, ingressName)
 		}
+		return nil, httputil.HttpError(500, httputil.ReasonFetchIngress).
+			MessageF("Failed fetching ingress %s/%s: %v", namespace, ingressName, err)
 	}
 	// Search for the tenant
-	ns, err := h.ctrl.NamespaceLister.Get(params["namespace"])
+	ns, err := h.ctrl.NamespaceLister.Get(namespace)
 	if err != nil {
-		httputil.HttpError(400, "FetchNamespaceErr").
-			MessageF("Failed fetching for namespace %q: %v", params["namespace"], err).
-			Write(w)
-		return
+		return nil, httputil.HttpError(400, httputil.ReasonFetchNamespace).
+			MessageF("Failed fetching for namespace %q: %v", namespace, err)
 	}
 	var tenant string
 	if ns.Labels != nil {
 		tenant = ns.Labels["allspark.sh/tenant"]
 	}
 	if tenant == "" {
-		httputil.HttpError(400, "TenantNotFound").
-			MessageF("This namespace doesn't have a tenant").
-			Write(w)
-		return
+		return nil, httputil.HttpError(400, httputil.ReasonTenantNotFound).
+			MessageF("This namespace doesn't have a tenant")
 	}
 	svc, err := h.ctrl.ServiceLister.Services(os.Getenv("POD_NAMESPACE")).Get(tenant)
 	if err != nil {
-		httputil.HttpError(400, "FetchServiceErr").
-			MessageF("Failed fetching frps service: %v", err).
-			Write(w)
-		return
+		return nil, httputil.HttpError(400, httputil.ReasonFetchService).
+			MessageF("Failed fetching frps service: %v", err)
 	}
+	// each request renders its own copy of the common section
+	common := *h.common
+	common.ServerPort = 0
 	for _, port := range svc.Spec.Ports {
 		if port.Name == "frps" {
-			h.common.ServerPort = port.Port
+			common.ServerPort = port.Port
 		}
 	}
-	if h.common.ServerPort == 0 {
-		httputil.HttpError(400, "PortNotFound").
-			MessageF("Failed finding FRPS port for service %q", svc.Name).
-			Write(w)
-		return
+	if common.ServerPort == 0 {
+		return nil, httputil.HttpError(400, httputil.ReasonPortNotFound).
+			MessageF("Failed finding FRPS port for service %q", svc.Name)
 	}
+	subdomainHost := h.ctrl.SubdomainHost(tenant)
 	var httpSections []api.FprcHTTP
 	// TODO: Check if has repeated paths for a given host
 	for _, r := range ing.Spec.Rules {
 		if r.HTTP == nil {
 			continue
 		}
+		host, subdomain, ok := controller.RuleHost(ing, r, subdomainHost)
+		if !ok {
+			glog.Warningf("%s/%s - host %q can't be exposed on tenant %q (domain %q), skipping",
+				ing.Namespace, ing.Name, r.Host, tenant, subdomainHost)
+			continue
+		}
 		for _, p := range r.HTTP.Paths {
 			frpcHTTP := api.FprcHTTP{}
-			frpcHTTP.Section = fmt.Sprintf("%s%s", r.Host, p.Path)
+			frpcHTTP.Section = fmt.Sprintf("%s%s", host, p.Path)
 			frpcHTTP.Type = "http"
 			frpcHTTP.LocalIP = fmt.Sprintf("%s.%s.svc.cluster.local",
 				p.Backend.ServiceName,
@@ -94,7 +172,11 @@ func (h *Handler) IngressToIni(w http.ResponseWriter, r *http.Request) {
 			)
 			frpcHTTP.LocalPort = p.Backend.ServicePort.IntVal
 			frpcHTTP.Locations = p.Path
-			frpcHTTP.CustomDomains = r.Host
+			if subdomain != "" {
+				frpcHTTP.Subdomain = subdomain
+			} else {
+				frpcHTTP.CustomDomains = host
+			}
 			if p.Backend.ServicePort.IntVal == 443 {
 				frpcHTTP.Type = "https"
 			}
@@ -104,35 +186,25 @@ func (h *Handler) IngressToIni(w http.ResponseWriter, r *http.Request) {
 	frpcini := ini.Empty()
 	for _, s := range httpSections {
 		if _, err := frpcini.NewSection(s.Section); err != nil {
-			httputil.HttpError(500, "InvalidIngressSectionErr").
-				MessageF("Invalid section: %v", err).
-				Write(w)
-			return
+			return nil, httputil.HttpError(500, httputil.ReasonInvalidIngressSection).
+				MessageF("Invalid section: %v", err)
 		}
 		if err := frpcini.Section(s.Section).ReflectFrom(&s); err != nil {
-			httputil.HttpError(500, "InvalidIngressMappingErr").
-				MessageF("Invalid mapping: %v", err).
-				Write(w)
-			return
+			return nil, httputil.HttpError(500, httputil.ReasonInvalidIngressMapping).
+				MessageF("Invalid mapping: %v", err)
 		}
 	}
 	c, err := frpcini.NewSection("common")
 	if err != nil {
-		httputil.HttpError(500, "InvalidSectionErr").
-			MessageF("Failed creating 'common' section: %v", err).
-			Write(w)
-		return
+		return nil, httputil.HttpError(500, httputil.ReasonInvalidSection).
+			MessageF("Failed creating 'common' section: %v", err)
 	}
-	if err := c.ReflectFrom(h.common); err != nil {
-		httputil.HttpError(500, "InvalidSectionErr").
-			MessageF("Failed injecting keys to section 'common': %v", err).
-			Write(w)
-		return
+	if err := c.ReflectFrom(&common); err != nil {
+		return nil, httputil.HttpError(500, httputil.ReasonInvalidSection).
+			MessageF("Failed injecting keys to section 'common': %v", err)
 	}
 	if len(frpcini.Sections()) == 0 {
 		glog.Warningf("found 0 sections, the ingress resource may have an error")
 	}
-	if _, err := frpcini.WriteTo(w); err != nil {
-		glog.Errorf("failed writing ini file: %v", err)
-	}
+	return frpcini, nil
 }
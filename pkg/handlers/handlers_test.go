@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sparkcorp/allspark/pkg/api"
+	"github.com/sparkcorp/allspark/pkg/conf"
+	"github.com/sparkcorp/allspark/pkg/controller"
+	ini "gopkg.in/ini.v1"
+	"k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestRouter serves the handlers from informer caches populated with objs
+func newTestRouter(cfg *conf.Config, objs ...runtime.Object) http.Handler {
+	kubecli := kubernetes.NewForConfigOrDie(&rest.Config{Host: "http://127.0.0.1:1"})
+	factory := informers.NewSharedInformerFactory(kubecli, 0)
+	ingInf := factory.Extensions().V1beta1().Ingresses()
+	nsInf := factory.Core().V1().Namespaces()
+	svcInf := factory.Core().V1().Services()
+	nodeInf := factory.Core().V1().Nodes()
+	podInf := factory.Core().V1().Pods()
+	secretInf := factory.Core().V1().Secrets()
+	for _, obj := range objs {
+		var indexer cache.Indexer
+		switch obj.(type) {
+		case *extensions.Ingress:
+			indexer = ingInf.Informer().GetIndexer()
+		case *v1.Namespace:
+			indexer = nsInf.Informer().GetIndexer()
+		case *v1.Service:
+			indexer = svcInf.Informer().GetIndexer()
+		}
+		indexer.Add(obj)
+	}
+	ctrl := controller.NewASController(kubecli, ingInf, nsInf, podInf, nodeInf, svcInf, podInf, secretInf, cfg)
+	h := New(ctrl, &api.FrpcCommon{ServerAddress: "frps.allspark.sh"})
+	r := mux.NewRouter()
+	h.Register(r)
+	return r
+}
+
+func newIngress(namespace, name string, hosts ...string) *extensions.Ingress {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, extensions.IngressRule{
+			Host: host,
+			IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+				Paths: []extensions.HTTPIngressPath{{
+					Path:    "/",
+					Backend: extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(8080)},
+				}},
+			}},
+		})
+	}
+	return ing
+}
+
+func tenantObjects(ing *extensions.Ingress) []runtime.Object {
+	return []runtime.Object{
+		ing,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   ing.Namespace,
+			Labels: map[string]string{"allspark.sh/tenant": "acme"},
+		}},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "allspark"},
+			Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "frps", Port: 20000}}},
+		},
+	}
+}
+
+func TestIngressToIniSubdomains(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	ing := newIngress("office", "web",
+		"",
+		"app.acme.edge.example.com",
+		"a.b.acme.edge.example.com",
+		"www.example.org",
+	)
+	tests := []struct {
+		name       string
+		baseDomain string
+		// sections maps the expected sections to its subdomain and custom domain
+		sections map[string][2]string
+	}{
+		{
+			name:       "tenant domain",
+			baseDomain: "edge.example.com",
+			sections: map[string][2]string{
+				"web-office.acme.edge.example.com/": {"web-office", ""},
+				"app.acme.edge.example.com/":        {"app", ""},
+				"www.example.org/":                  {"", "www.example.org"},
+			},
+		},
+		{
+			name: "without tenant domain",
+			sections: map[string][2]string{
+				"app.acme.edge.example.com/": {"", "app.acme.edge.example.com"},
+				"a.b.acme.edge.example.com/": {"", "a.b.acme.edge.example.com"},
+				"www.example.org/":           {"", "www.example.org"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(&conf.Config{TenantBaseDomain: tt.baseDomain}, tenantObjects(ing)...)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/namespaces/office/ingress/web", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.name, w.Code, w.Body.String())
+		}
+		frpcini, err := ini.Load(w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s: failed loading ini: %v", tt.name, err)
+		}
+		var got []string
+		for _, sec := range frpcini.Sections() {
+			if sec.HasKey("type") {
+				got = append(got, sec.Name())
+			}
+		}
+		if len(got) != len(tt.sections) {
+			t.Errorf("%s: expected the sections %v, got %v", tt.name, tt.sections, got)
+		}
+		for name, domains := range tt.sections {
+			sec, err := frpcini.GetSection(name)
+			if err != nil {
+				t.Errorf("%s: expected the section %q, got %v", tt.name, name, got)
+				continue
+			}
+			subdomain, custom := sec.Key("subdomain").String(), sec.Key("custom_domains").String()
+			if subdomain != domains[0] || custom != domains[1] {
+				t.Errorf("%s: expected section %q with subdomain=%q and custom_domains=%q, got %q and %q",
+					tt.name, name, domains[0], domains[1], subdomain, custom)
+			}
+		}
+		if port := frpcini.Section("common").Key("server_port").String(); port != "20000" {
+			t.Errorf("%s: expected the frps port of the tenant, got %q", tt.name, port)
+		}
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz",W/"abc"`, true},
+		{`*`, true},
+		{``, false},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{`"abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.ifNoneMatch, `"abc"`); got != tt.want {
+			t.Errorf("etagMatch(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz"`, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v2/namespaces/office/ingresses/web", nil)
+		if tt.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		if got := notModified(w, r, "abc"); got != tt.want {
+			t.Errorf("notModified(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+		if etag := w.Header().Get("ETag"); etag != `W/"abc"` {
+			t.Errorf("notModified(%q) ETag = %q, want a weak ETag", tt.ifNoneMatch, etag)
+		}
+		if tt.want && w.Code != http.StatusNotModified {
+			t.Errorf("notModified(%q) status = %d, want %d", tt.ifNoneMatch, w.Code, http.StatusNotModified)
+		}
+	}
+}
+
+func TestIngressToIniNotModified(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	router := newTestRouter(&conf.Config{}, tenantObjects(newIngress("office", "web", "app.example.com"))...)
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/v1/namespaces/office/ingress/web", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected the ini with an etag, got %d %q", w.Code, etag)
+	}
+	if w = get(etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected an unchanged ini to be answered with 304, got %d %q", w.Code, w.Body.String())
+	}
+	if w = get(`"0123456789abcdef"`); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("expected a stale etag to be answered with the ini, got %d", w.Code)
+	}
+}
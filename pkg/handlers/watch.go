@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchHeartbeat keeps idle streams open through proxies
+const watchHeartbeat = 30 * time.Second
+
+// broadcaster notifies the watchers of each ingress
+type broadcaster struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{watchers: map[string]map[chan struct{}]struct{}{}}
+}
+
+func (b *broadcaster) subscribe(key string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if b.watchers[key] == nil {
+		b.watchers[key] = map[chan struct{}]struct{}{}
+	}
+	b.watchers[key][ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(key string, ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.watchers[key], ch)
+	if len(b.watchers[key]) == 0 {
+		delete(b.watchers, key)
+	}
+}
+
+// notify wakes up the watchers of the keys matching the filter, a
+// pending notification is never duplicated
+func (b *broadcaster) notify(match func(key string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, watchers := range b.watchers {
+		if !match(key) {
+			continue
+		}
+		for ch := range watchers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// AddEventHandlers notifies the watchers when the ini of an ingress could
+// have changed: the ingress itself, its namespace or the frps services
+func (h *Handler) AddEventHandlers(ingInf, nsInf, svcInf cache.SharedInformer) {
+	ingressChanged := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			return
+		}
+		h.watchers.notify(func(k string) bool { return k == key })
+	}
+	ingInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ingressChanged,
+		UpdateFunc: func(o, n interface{}) { ingressChanged(n) },
+		DeleteFunc: ingressChanged,
+	})
+	namespaceChanged := func(obj interface{}) {
+		name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			return
+		}
+		h.watchers.notify(func(k string) bool { return strings.HasPrefix(k, name+"/") })
+	}
+	nsInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    namespaceChanged,
+		UpdateFunc: func(o, n interface{}) { namespaceChanged(n) },
+		DeleteFunc: namespaceChanged,
+	})
+	all := func(interface{}) { h.watchers.notify(func(string) bool { return true }) }
+	svcInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    all,
+		UpdateFunc: func(o, n interface{}) { all(n) },
+		DeleteFunc: all,
+	})
+}
+
+// acquireWatch counts a watch of the client of the request, it returns
+// false when the client reached the limit
+func (h *Handler) acquireWatch(r *http.Request) (release func(), ok bool) {
+	if h.maxWatches <= 0 {
+		return func() {}, true
+	}
+	client := h.clientIP(r)
+	h.watchesMu.Lock()
+	defer h.watchesMu.Unlock()
+	if h.watches[client] >= h.maxWatches {
+		return nil, false
+	}
+	h.watches[client]++
+	return func() {
+		h.watchesMu.Lock()
+		defer h.watchesMu.Unlock()
+		if h.watches[client]--; h.watches[client] <= 0 {
+			delete(h.watches, client)
+		}
+	}, true
+}
+
+// WatchIni streams an event each time the ini of an ingress changes, the id of
+// the event is the hash of the ini. Clients resume with the Last-Event-ID header,
+// the current ini is only sent on connect when its hash differs from it.
+func (h *Handler) WatchIni(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	namespace, ingressName := params["namespace"], params["name"]
+	h.recordSyncer(r, "Ingress", namespace, ingressName)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httputil.HttpError(500, httputil.ReasonStreamingUnsupported).
+			MessageF("The connection doesn't support streaming").
+			Write(w)
+		return
+	}
+	release, ok := h.acquireWatch(r)
+	if !ok {
+		apiErr := httputil.HttpError(http.StatusTooManyRequests, httputil.ReasonTooManyRequests).
+			MessageF("Too many watches open by this client, the limit is %d", h.maxWatches)
+		apiErr.AddHeader("Retry-After", strconv.Itoa(int(watchHeartbeat.Seconds())))
+		apiErr.Write(w)
+		return
+	}
+	defer release()
+	notifications := h.watchers.subscribe(namespace + "/" + ingressName)
+	defer h.watchers.unsubscribe(namespace+"/"+ingressName, notifications)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lastID, lastReason := r.Header.Get("Last-Event-ID"), ""
+	send := func() error {
+		_, id, apiErr := h.renderConfig(namespace, ingressName)
+		if apiErr != nil {
+			if apiErr.Reason == lastReason {
+				return nil
+			}
+			lastReason = apiErr.Reason
+			data, _ := json.Marshal(apiErr)
+			_, err := fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			return err
+		}
+		lastReason = ""
+		if id == lastID {
+			return nil
+		}
+		lastID = id
+		_, err := fmt.Fprintf(w, "id: %s\nevent: changed\ndata: %s\n\n", id, id)
+		return err
+	}
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		if err := send(); err != nil {
+			glog.V(2).Infof("%s/%s - watch closed: %v", namespace, ingressName, err)
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-notifications:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/conf"
+	"github.com/sparkcorp/allspark/pkg/httputil"
+	"github.com/sparkcorp/allspark/pkg/request"
+)
+
+func TestBroadcaster(t *testing.T) {
+	b := newBroadcaster()
+	web := b.subscribe("office/web")
+	api := b.subscribe("office/api")
+	pending := func(ch chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	b.notify(func(key string) bool { return key == "office/web" })
+	b.notify(func(key string) bool { return key == "office/web" })
+	if !pending(web) || pending(web) {
+		t.Errorf("expected a single pending notification")
+	}
+	if pending(api) {
+		t.Errorf("expected only the matching watchers to be notified")
+	}
+
+	b.unsubscribe("office/web", web)
+	b.notify(func(string) bool { return true })
+	if pending(web) || !pending(api) {
+		t.Errorf("expected only the subscribed watchers to be notified")
+	}
+	b.unsubscribe("office/api", api)
+	if len(b.watchers) != 0 {
+		t.Errorf("expected the keys without watchers to be removed, got %v", b.watchers)
+	}
+}
+
+func TestWatchIni(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "allspark")
+	defer os.Unsetenv("POD_NAMESPACE")
+	srv := httptest.NewServer(newTestRouter(&conf.Config{}, tenantObjects(newIngress("office", "web", "app.example.com"))...))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+
+	next := func(resource string) request.Event {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		w := request.New(nil, addr).Resource(resource).Context(ctx).Watch("")
+		defer w.Stop()
+		select {
+		case event := <-w.ResultChan():
+			return event
+		case <-ctx.Done():
+			t.Fatalf("%s: timed out waiting for an event", resource)
+		}
+		return request.Event{}
+	}
+	event := next("/v1/namespaces/office/ingress/web/watch")
+	if event.Type != "changed" || event.ID == "" || string(event.Data) != event.ID {
+		t.Errorf("expected a changed event with the hash of the ini, got %q", event)
+	}
+	event = next("/v1/namespaces/office/ingress/missing/watch")
+	if event.Type != "error" {
+		t.Errorf("expected an error event for a missing ingress, got %q", event)
+	}
+}
+
+func TestAcquireWatch(t *testing.T) {
+	h := New(nil, nil)
+	h.LimitWatches(httputil.ClientIP("", nil), 1)
+	newRequest := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/v1/namespaces/office/ingress/web/watch", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+	release, ok := h.acquireWatch(newRequest("10.0.0.1:1234"))
+	if !ok {
+		t.Fatalf("expected the first watch of the client to be allowed")
+	}
+	if _, ok := h.acquireWatch(newRequest("10.0.0.1:4321")); ok {
+		t.Errorf("expected the second watch of the client to be refused")
+	}
+	if _, ok := h.acquireWatch(newRequest("10.0.0.2:1234")); !ok {
+		t.Errorf("expected the watch of another client to be allowed")
+	}
+	release()
+	if _, ok := h.acquireWatch(newRequest("10.0.0.1:1234")); !ok {
+		t.Errorf("expected a watch to be allowed once the previous one is released")
+	}
+}
@@ -0,0 +1,75 @@
+package httputil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// maxURLLength rejects absurd paths before routing them
+const maxURLLength = 2048
+
+// MaxBytes rejects requests with a body or an url larger than the limits,
+// bodies without a Content-Length are cut when reading past maxBodyBytes
+func MaxBytes(maxBodyBytes int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(r.URL.RequestURI()) > maxURLLength {
+				HttpError(http.StatusRequestURITooLong, ReasonRequestTooLarge).
+					MessageF("The url exceeds %d bytes", maxURLLength).
+					Write(w)
+				return
+			}
+			if maxBodyBytes > 0 {
+				if r.ContentLength > maxBodyBytes {
+					HttpError(http.StatusRequestEntityTooLarge, ReasonRequestTooLarge).
+						MessageF("The body exceeds %d bytes", maxBodyBytes).
+						Write(w)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ParseCIDRs parses a list of networks, single addresses are accepted
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Denylist rejects the clients of the given networks
+func Denylist(networks []*net.IPNet, clientIP ClientIPFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := net.ParseIP(clientIP(r)); ip != nil {
+				for _, network := range networks {
+					if network.Contains(ip) {
+						HttpError(http.StatusForbidden, ReasonClientDenied).
+							MessageF("The client is not allowed").
+							Write(w)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
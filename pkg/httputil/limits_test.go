@@ -0,0 +1,109 @@
+package httputil
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		contains []string
+		excludes []string
+		wantErr  bool
+	}{
+		{name: "empty"},
+		{
+			name:     "networks",
+			cidrs:    []string{"10.0.0.0/8", "fd00::/8"},
+			contains: []string{"10.1.2.3", "fd00::1"},
+			excludes: []string{"11.0.0.1", "fe80::1"},
+		},
+		{
+			name:     "single addresses",
+			cidrs:    []string{"192.168.0.1", "::1"},
+			contains: []string{"192.168.0.1", "::1"},
+			excludes: []string{"192.168.0.2", "::2"},
+		},
+		{name: "invalid network", cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "invalid address", cidrs: []string{"10.0.0.0/8", "my-proxy"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		networks, err := ParseCIDRs(tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseCIDRs(%q) error = %v, wantErr %v", tt.name, tt.cidrs, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(networks) != len(tt.cidrs) {
+			t.Errorf("%s: ParseCIDRs(%q) returned %d networks, want %d", tt.name, tt.cidrs, len(networks), len(tt.cidrs))
+		}
+		for _, addr := range tt.contains {
+			if !containsIP(networks, addr) {
+				t.Errorf("%s: ParseCIDRs(%q) doesn't contain %s", tt.name, tt.cidrs, addr)
+			}
+		}
+		for _, addr := range tt.excludes {
+			if containsIP(networks, addr) {
+				t.Errorf("%s: ParseCIDRs(%q) contains %s", tt.name, tt.cidrs, addr)
+			}
+		}
+	}
+}
+
+func containsIP(networks []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMaxBytes(t *testing.T) {
+	h := MaxBytes(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"small body", "/", "12345678", http.StatusOK},
+		{"large body", "/", "123456789", http.StatusRequestEntityTooLarge},
+		{"long url", "/" + strings.Repeat("a", maxURLLength), "", http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Denylist(networks, ClientIP("", nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for remoteAddr, want := range map[string]int{
+		"10.1.2.3:1234":    http.StatusForbidden,
+		"192.168.0.1:1234": http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", remoteAddr, want, w.Code)
+		}
+	}
+}
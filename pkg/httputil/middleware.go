@@ -0,0 +1,210 @@
+package httputil
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// RequestIDHeader carries the id of a request, it's generated when the client doesn't send one
+const RequestIDHeader = "X-Request-Id"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the middlewares, the first one is the outermost
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequestIDFromContext returns the id of the request, empty if the
+// RequestID middleware wasn't used
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// RequestID propagates the request id of the client or generates a new one,
+// it's echoed on the response header and on ApiError bodies
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs each request once it's served
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		status := rw.status
+		if status == 0 && !rw.hijacked {
+			status = http.StatusOK
+		}
+		glog.Infof("method=%s path=%q status=%d bytes=%d duration=%v remote=%s request_id=%s",
+			r.Method, r.URL.Path, status, rw.written, time.Since(start), r.RemoteAddr, RequestIDFromContext(r.Context()))
+	})
+}
+
+// Recover turns a panic of the handler into a 500 ApiError
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				glog.Errorf("panic serving %s %s (request_id=%s): %v\n%s",
+					r.Method, r.URL.Path, RequestIDFromContext(r.Context()), err, debug.Stack())
+				if rw.status == 0 && !rw.hijacked {
+					HttpError(http.StatusInternalServerError, ReasonInternalError).
+						MessageF("Internal error processing the request").
+						Write(rw)
+				}
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Deadline answers a 503 ApiError to the requests not served within the timeout
+// and cancels their context, requests matched by skip (e.g.: streams) aren't bounded
+func Deadline(timeout time.Duration, skip func(r *http.Request) bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if timeout <= 0 || (skip != nil && skip(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			apiErr := HttpError(http.StatusServiceUnavailable, ReasonTimeout).
+				MessageF("The request wasn't served within %v", timeout)
+			apiErr.RequestID = RequestIDFromContext(r.Context())
+			body, _ := json.Marshal(apiErr)
+			http.TimeoutHandler(next, timeout, string(body)).ServeHTTP(&timeoutWriter{ResponseWriter: w}, r)
+		})
+	}
+}
+
+// timeoutWriter sets the content type of the body written by http.TimeoutHandler
+// when the request times out, the answers of the handler keep their own headers
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// IsStream returns true for long lived requests: event streams and tunnels
+func IsStream(r *http.Request) bool {
+	return r.Method == http.MethodConnect ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		strings.HasSuffix(r.URL.Path, "/watch")
+}
+
+// CORS allows browsers of the given origins to call the api, "*" allows any
+// origin. Preflight requests are answered without calling the handler.
+func CORS(origins []string) Middleware {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(allowed["*"] || allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, "+RequestIDHeader)
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match, Last-Event-ID, "+RequestIDHeader)
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseWriter records the status of a response, it keeps
+// streaming and hijacking available to the handlers
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	written  int
+	hijacked bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.written += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the connection doesn't support hijacking")
+	}
+	conn, buf, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, buf, err
+}
@@ -0,0 +1,207 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), trace("first"), trace("second"), trace("third"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if want := []string{"first", "second", "third", "handler"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected the first middleware to be the outermost, got %v", order)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var fromContext string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = RequestIDFromContext(r.Context())
+	}))
+	tests := []struct {
+		name     string
+		clientID string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"propagated", "abc-123", true},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.clientID != "" {
+			r.Header.Set(RequestIDHeader, tt.clientID)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		id := w.Header().Get(RequestIDHeader)
+		if id == "" || id != fromContext {
+			t.Errorf("%s: expected the request id on the response and the context, got %q and %q", tt.name, id, fromContext)
+		}
+		if (id == tt.clientID) != tt.keep {
+			t.Errorf("%s: expected keep=%v, got %q", tt.name, tt.keep, id)
+		}
+	}
+	if RequestIDFromContext(httptest.NewRequest("GET", "/", nil).Context()) != "" {
+		t.Errorf("expected no request id without the middleware")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("boom")
+	}), RequestID, Recover)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a 500, got %d", w.Code)
+	}
+	var apiErr ApiError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("failed decoding the error: %v", err)
+	}
+	if apiErr.Reason != ReasonInternalError || apiErr.RequestID != "abc-123" {
+		t.Errorf("expected an internal error with the request id, got %#v", apiErr)
+	}
+
+	// the status can't be changed once it's written
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/partial", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("expected the written response to be kept, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	var hasDeadline bool
+	h := Deadline(time.Minute, IsStream)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+	}))
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		accept string
+		want   bool
+	}{
+		{"request", "GET", "/v1/namespaces/office/ingress/web", "", true},
+		{"watch", "GET", "/v1/namespaces/office/ingress/web/watch", "", false},
+		{"event stream", "GET", "/events", "text/event-stream", false},
+		{"tunnel", "CONNECT", "/", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if hasDeadline != tt.want {
+			t.Errorf("%s: expected deadline=%v", tt.name, tt.want)
+		}
+	}
+	Deadline(0, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if hasDeadline {
+		t.Errorf("expected no deadline when the timeout is disabled")
+	}
+}
+
+func TestDeadlineTimeout(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}), RequestID, Deadline(10*time.Millisecond, IsStream))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a 503 json answer, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var apiErr ApiError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("failed decoding the timeout answer: %v", err)
+	}
+	if apiErr.Reason != ReasonTimeout || apiErr.RequestID == "" || apiErr.RequestID != w.Header().Get(RequestIDHeader) {
+		t.Errorf("expected a Timeout error with the request id, got %#v", apiErr)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the answer of the handler, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	h := CORS([]string{"https://dash.example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantOrigin string
+		wantCalled bool
+	}{
+		{"allowed origin", "GET", "https://dash.example.com", false, "https://dash.example.com", true},
+		{"other origin", "GET", "https://evil.example.com", false, "", true},
+		{"same origin", "GET", "", false, "", true},
+		{"preflight", "OPTIONS", "https://dash.example.com", true, "https://dash.example.com", false},
+	}
+	for _, tt := range tests {
+		called = false
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.preflight {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: expected the allowed origin %q, got %q", tt.name, tt.wantOrigin, got)
+		}
+		if called != tt.wantCalled {
+			t.Errorf("%s: expected called=%v", tt.name, tt.wantCalled)
+		}
+		if tt.preflight && w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected a 204, got %d", tt.name, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://any.example.com")
+	CORS([]string{"*"})(http.NotFoundHandler()).ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://any.example.com" {
+		t.Errorf("expected any origin to be allowed, got %q", got)
+	}
+}
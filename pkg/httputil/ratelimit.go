@@ -0,0 +1,180 @@
+package httputil
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdleTTL is how long the bucket of an idle key is kept
+	limiterIdleTTL = 10 * time.Minute
+	// maxLimiterKeys bounds the buckets kept in memory, new keys share
+	// an overflow bucket until idle keys expire
+	maxLimiterKeys = 10000
+	overflowKey    = "\x00overflow"
+)
+
+var namespacePath = regexp.MustCompile(`^/v[0-9]+[a-z0-9]*/namespaces/([^/]+)/`)
+
+// NamespaceFromPath returns the namespace of an api path, e.g.: /v1/namespaces/<namespace>/...
+func NamespaceFromPath(path string) string {
+	if m := namespacePath.FindStringSubmatch(path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// ClientIPFunc returns the address of the client of a request
+type ClientIPFunc func(r *http.Request) string
+
+// ClientIP resolves the address of the client from the peer of the connection.
+// The header (e.g.: X-Forwarded-For) is only read when the peer is one of the
+// trusted proxies, the client is the rightmost address which isn't a trusted
+// proxy since the addresses on its left could be forged by the client.
+func ClientIP(header string, trustedProxies []*net.IPNet) ClientIPFunc {
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, network := range trustedProxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) string {
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peer = r.RemoteAddr
+		}
+		if header == "" || !trusted(peer) {
+			return peer
+		}
+		hops := strings.Split(strings.Join(r.Header[http.CanonicalHeaderKey(header)], ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if !trusted(hop) {
+				return hop
+			}
+			peer = hop
+		}
+		return peer
+	}
+}
+
+// Limit is a token bucket, a zero QPS disables it
+type Limit struct {
+	QPS   float64
+	Burst int
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// keyedLimiter keeps a token bucket for each key, up to maxKeys
+type keyedLimiter struct {
+	limit   Limit
+	maxKeys int
+
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+	lastGC   time.Time
+}
+
+func newKeyedLimiter(limit Limit) *keyedLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Max(1, math.Ceil(limit.QPS)))
+	}
+	return &keyedLimiter{limit: limit, maxKeys: maxLimiterKeys, limiters: map[string]*limiterEntry{}}
+}
+
+// reserve takes a token of the key, it returns how long to wait
+// when the bucket is empty
+func (l *keyedLimiter) reserve(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, known := l.limiters[key]
+	full := !known && len(l.limiters) >= l.maxKeys
+	// a full map is collected right away, at most once per second
+	if now.Sub(l.lastGC) > limiterIdleTTL || full && now.Sub(l.lastGC) > time.Second {
+		for k, e := range l.limiters {
+			if now.Sub(e.lastSeen) > limiterIdleTTL {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastGC = now
+	}
+	e, ok := l.limiters[key]
+	if !ok && len(l.limiters) >= l.maxKeys {
+		key = overflowKey
+		e, ok = l.limiters[key]
+	}
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(l.limit.QPS), l.limit.Burst)}
+		l.limiters[key] = e
+	}
+	e.lastSeen = now
+	res := e.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// RateLimit limits the requests of each client and of each namespace of the
+// path with token buckets, throttled requests get a 429 with Retry-After.
+// Only namespaces which exist are limited on their own, paths of unknown
+// namespaces are limited by client.
+func RateLimit(perClient, perNamespace Limit, clientIP ClientIPFunc, namespaceExists func(string) bool) Middleware {
+	var clients, namespaces *keyedLimiter
+	if perClient.QPS > 0 {
+		clients = newKeyedLimiter(perClient)
+	}
+	if perNamespace.QPS > 0 {
+		namespaces = newKeyedLimiter(perNamespace)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if clients != nil {
+				if delay := clients.reserve(clientIP(r)); delay > 0 {
+					tooManyRequests(w, delay, "Too many requests from this client")
+					return
+				}
+			}
+			if namespace := NamespaceFromPath(r.URL.Path); namespaces != nil && namespace != "" && namespaceExists(namespace) {
+				if delay := namespaces.reserve(namespace); delay > 0 {
+					tooManyRequests(w, delay, "Too many requests for namespace %q", namespace)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, delay time.Duration, msg string, a ...interface{}) {
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	apiErr := HttpError(http.StatusTooManyRequests, ReasonTooManyRequests).MessageF(msg, a...)
+	apiErr.AddHeader("Retry-After", strconv.Itoa(seconds))
+	apiErr.Write(w)
+}
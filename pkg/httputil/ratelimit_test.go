@@ -0,0 +1,173 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	l := newKeyedLimiter(Limit{QPS: 1, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if delay := l.reserve("a"); delay != 0 {
+			t.Fatalf("reserve(a) #%d = %v, want no delay within the burst", i, delay)
+		}
+	}
+	if delay := l.reserve("a"); delay <= 0 || delay > time.Second {
+		t.Errorf("reserve(a) = %v, want a delay up to 1s once the burst is taken", delay)
+	}
+	if delay := l.reserve("b"); delay != 0 {
+		t.Errorf("reserve(b) = %v, want no delay, keys have their own bucket", delay)
+	}
+	// throttled requests don't take tokens
+	if delay := l.reserve("a"); delay > time.Second {
+		t.Errorf("reserve(a) = %v, a throttled request took a token", delay)
+	}
+}
+
+func TestKeyedLimiterBurstDefault(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  int
+	}{
+		{Limit{QPS: 0.5}, 1},
+		{Limit{QPS: 2.5}, 3},
+		{Limit{QPS: 5, Burst: 10}, 10},
+	}
+	for _, tt := range tests {
+		if got := newKeyedLimiter(tt.limit).limit.Burst; got != tt.want {
+			t.Errorf("newKeyedLimiter(%+v) burst = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestKeyedLimiterMaxKeys(t *testing.T) {
+	l := newKeyedLimiter(Limit{QPS: 1, Burst: 1})
+	l.maxKeys = 3
+	for i := 0; i < 10; i++ {
+		l.reserve(strconv.Itoa(i))
+	}
+	// the keys and the overflow bucket
+	if len(l.limiters) != l.maxKeys+1 {
+		t.Fatalf("%d buckets kept, want %d", len(l.limiters), l.maxKeys+1)
+	}
+	if _, ok := l.limiters[overflowKey]; !ok {
+		t.Fatalf("the keys past the limit don't share the overflow bucket")
+	}
+	// the overflow bucket was taken by the keys past the limit
+	if delay := l.reserve("new"); delay == 0 {
+		t.Errorf("reserve(new) = 0, want the delay of the overflow bucket")
+	}
+	// known keys keep their own bucket
+	if delay := l.reserve("0"); delay == 0 {
+		t.Errorf("reserve(0) = 0, want the delay of its own bucket")
+	}
+
+	// idle keys are collected when the map is full
+	idle := time.Now().Add(-2 * limiterIdleTTL)
+	for _, e := range l.limiters {
+		e.lastSeen = idle
+	}
+	l.lastGC = time.Time{}
+	if delay := l.reserve("new"); delay != 0 {
+		t.Errorf("reserve(new) = %v, want no delay once idle keys are collected", delay)
+	}
+	if _, ok := l.limiters["new"]; !ok || len(l.limiters) != 1 {
+		t.Errorf("keys after collecting idle ones = %d, want only the new key", len(l.limiters))
+	}
+}
+
+func TestNamespaceFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/namespaces/office/ingress/web", "office"},
+		{"/v2/namespaces/office/ingresses/web/watch", "office"},
+		{"/v1beta1/namespaces/office/ingresses/web", "office"},
+		{"/v1/namespaces/office", ""},
+		{"/version", ""},
+		{"/apis", ""},
+	}
+	for _, tt := range tests {
+		if got := NamespaceFromPath(tt.path); got != tt.want {
+			t.Errorf("NamespaceFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no header configured", "", "10.0.0.1:1234", []string{"1.1.1.1"}, "10.0.0.1"},
+		{"untrusted peer", "X-Forwarded-For", "8.8.8.8:1234", []string{"1.1.1.1"}, "8.8.8.8"},
+		{"trusted peer", "X-Forwarded-For", "10.0.0.1:1234", []string{"1.1.1.1"}, "1.1.1.1"},
+		{"forged hops are skipped", "X-Forwarded-For", "10.0.0.1:1234", []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{"trusted hops are skipped", "X-Forwarded-For", "10.0.0.1:1234", []string{"1.1.1.1, 192.168.0.1, 10.0.0.2"}, "1.1.1.1"},
+		{"repeated headers", "X-Forwarded-For", "10.0.0.1:1234", []string{"6.6.6.6", "1.1.1.1"}, "1.1.1.1"},
+		{"only trusted hops", "X-Forwarded-For", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"empty header", "X-Forwarded-For", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"custom header", "X-Real-Ip", "10.0.0.1:1234", []string{"1.1.1.1"}, "1.1.1.1"},
+		{"remote without port", "X-Forwarded-For", "8.8.8.8", nil, "8.8.8.8"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+		for _, value := range tt.forwarded {
+			header := tt.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			r.Header.Add(header, value)
+		}
+		if got := ClientIP(tt.header, trusted)(r); got != tt.want {
+			t.Errorf("%s: ClientIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	exists := func(namespace string) bool { return namespace == "office" }
+	h := RateLimit(Limit{QPS: 1, Burst: 1}, Limit{QPS: 1, Burst: 2}, ClientIP("", nil), exists)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remoteAddr, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		path       string
+		want       int
+	}{
+		{"first request", "10.0.0.1:1234", "/v1/namespaces/office/ingress/web", http.StatusOK},
+		{"client throttled", "10.0.0.1:1234", "/v1/namespaces/other/ingress/web", http.StatusTooManyRequests},
+		{"other client", "10.0.0.2:1234", "/v1/namespaces/office/ingress/web", http.StatusOK},
+		{"namespace throttled", "10.0.0.3:1234", "/v1/namespaces/office/ingress/web", http.StatusTooManyRequests},
+		{"without namespace", "10.0.0.4:1234", "/metrics", http.StatusOK},
+		{"unknown namespace", "10.0.0.5:1234", "/v1/namespaces/office-1/ingress/web", http.StatusOK},
+		{"unknown namespace limited by client", "10.0.0.5:1234", "/v1/namespaces/office-2/ingress/web", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		w := serve(tt.remoteAddr, tt.path)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: expected a Retry-After of 1s, got %q", tt.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
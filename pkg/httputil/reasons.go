@@ -0,0 +1,32 @@
+package httputil
+
+// Reasons of the ApiError responses, clients branch on them
+// to know why a request failed
+const (
+	ReasonUnknown       = "Unknown"
+	ReasonInternalError = "InternalError"
+
+	// limits of the handler chain
+	ReasonTooManyRequests = "TooManyRequests"
+	ReasonRequestTooLarge = "RequestTooLarge"
+	ReasonClientDenied    = "ClientDenied"
+	ReasonTimeout         = "Timeout"
+
+	// ini-server
+	ReasonIngressNotFound       = "IngressNotFound"
+	ReasonFetchIngress          = "FetchIngressErr"
+	ReasonFetchNamespace        = "FetchNamespaceErr"
+	ReasonTenantNotFound        = "TenantNotFound"
+	ReasonFetchService          = "FetchServiceErr"
+	ReasonPortNotFound          = "PortNotFound"
+	ReasonInvalidIngressSection = "InvalidIngressSectionErr"
+	ReasonInvalidIngressMapping = "InvalidIngressMappingErr"
+	ReasonInvalidSection        = "InvalidSectionErr"
+	ReasonStreamingUnsupported  = "StreamingUnsupported"
+
+	// egress proxy
+	ReasonMethodNotAllowed   = "MethodNotAllowed"
+	ReasonKubeletNotFound    = "KubeletNotFound"
+	ReasonTunnelUnreachable  = "TunnelUnreachable"
+	ReasonHijackNotSupported = "HijackNotSupported"
+)
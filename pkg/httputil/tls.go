@@ -0,0 +1,99 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// certReloader serves a certificate which is loaded again when its files
+// change, e.g.: when a mounted secret is updated
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lastModified returns the most recent modification of the cert and key files
+func (c *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return last, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func (c *certReloader) load() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return fmt.Errorf("failed reading certificate: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed loading certificate: %v", err)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if modTime, err := c.lastModified(); err == nil && !modTime.Equal(c.modTime) {
+		// a broken pair (e.g.: in the middle of an update) keeps the current one
+		if err := c.load(); err != nil {
+			glog.Warningf("failed reloading certificate %q: %v", c.certFile, err)
+		} else {
+			glog.Infof("certificate %q reloaded", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+// ServerTLSConfig serves the given certificate, reloading it when the files
+// change. Clients must present a certificate signed by clientCAFile when set.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		data, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading client ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,124 @@
+package httputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate of the given name in dir
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cfg *tls.Config) string {
+	cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed parsing certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestServerTLSConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "first")
+
+	cfg, err := ServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientAuth != tls.NoClientCert || cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected tls 1.2 without client certificates, got %#v", cfg)
+	}
+	if name := commonName(t, cfg); name != "first" {
+		t.Fatalf("expected the first certificate, got %q", name)
+	}
+
+	// a rotated pair is picked up on the next handshake
+	writeCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if name := commonName(t, cfg); name != "second" {
+		t.Errorf("expected the rotated certificate, got %q", name)
+	}
+
+	// a broken pair keeps the current certificate
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(keyFile, future, future)
+	if name := commonName(t, cfg); name != "second" {
+		t.Errorf("expected the current certificate to be kept, got %q", name)
+	}
+}
+
+func TestServerTLSConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "server")
+	garbage := filepath.Join(dir, "garbage.pem")
+	ioutil.WriteFile(garbage, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name                      string
+		certFile, keyFile, caFile string
+	}{
+		{"missing cert", filepath.Join(dir, "missing.crt"), keyFile, ""},
+		{"invalid pair", garbage, keyFile, ""},
+		{"missing client ca", certFile, keyFile, filepath.Join(dir, "missing.pem")},
+		{"invalid client ca", certFile, keyFile, garbage},
+	}
+	for _, tt := range tests {
+		if _, err := ServerTLSConfig(tt.certFile, tt.keyFile, tt.caFile); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	cfg, err := ServerTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert || cfg.ClientCAs == nil {
+		t.Errorf("expected client certificates to be required, got %v", cfg.ClientAuth)
+	}
+}
@@ -10,7 +10,9 @@ type ApiError struct {
 	StatusCode int    `json:"statusCode"`
 	Message    string `json:"message"`
 	Reason     string `json:"reason"`
-	headers    map[string]string
+	// RequestID is echoed from the response header set by the RequestID middleware
+	RequestID string `json:"requestId,omitempty"`
+	headers   map[string]string
 }
 
 func (e *ApiError) AddHeader(key, value string) {
@@ -31,6 +33,9 @@ func (e *ApiError) Cause(cause string) *ApiError {
 }
 
 func (e *ApiError) Write(w http.ResponseWriter) error {
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(RequestIDHeader)
+	}
 	w.Header().Add("Content-Type", "application/json")
 	for key, value := range e.headers {
 		w.Header().Add(key, value)
@@ -41,7 +46,7 @@ func (e *ApiError) Write(w http.ResponseWriter) error {
 
 func HttpError(statusCode int, reason string) *ApiError {
 	if reason == "" {
-		reason = "Unknown"
+		reason = ReasonUnknown
 	}
 	return &ApiError{Reason: reason, StatusCode: statusCode}
 }
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const namespace = "allspark"
+
+var (
+	// ReconcileErrors counts the sync errors of each task queue
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of errors syncing an item of a task queue.",
+	}, []string{"queue"})
+
+	// SyncDuration observes how long it takes to sync an ini file
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "ini_sync",
+		Name:      "sync_duration_seconds",
+		Help:      "Time spent syncing the frpc ini file.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	// SyncFailures counts the failed syncs of an ini file
+	SyncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ini_sync",
+		Name:      "sync_failures_total",
+		Help:      "Total number of failed syncs of the frpc ini file.",
+	}, []string{"type"})
+
+	// Reloads counts the frpc reloads by its result: success, rejected, rollback or error
+	Reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ini_sync",
+		Name:      "reloads_total",
+		Help:      "Total number of frpc reloads by result.",
+	}, []string{"result"})
+)
+
+// RegisterController registers the metrics of the controller manager,
+// it must be called before creating any task queue.
+func RegisterController() {
+	workqueue.SetProvider(workqueueProvider{})
+	prometheus.MustRegister(ReconcileErrors)
+	prometheus.MustRegister(workqueueMetrics...)
+}
+
+// RegisterSyncer registers the metrics of ini-sync
+func RegisterSyncer() {
+	prometheus.MustRegister(SyncDuration, SyncFailures, Reloads)
+}
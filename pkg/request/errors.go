@@ -1,27 +1,115 @@
 package request
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
 )
 
+// HTTPError is a non successful response, the reason and message are
+// decoded from an httputil.ApiError body when the server sends one
 type HTTPError struct {
 	statusCode int
 	message    string
+	reason     string
+	retryAfter time.Duration
 }
 
 func (h *HTTPError) Error() string {
+	if h.reason != "" {
+		return fmt.Sprintf("[%d] %s: %s", h.statusCode, h.reason, h.message)
+	}
 	return fmt.Sprintf("[%d] %s", h.statusCode, h.message)
 }
 
-func NewHTTPError(statusCode int, message string, format ...interface{}) *HTTPError {
-	return &HTTPError{statusCode: statusCode, message: fmt.Sprintf(message, format)}
+// StatusCode returns the status code of the response
+func (h *HTTPError) StatusCode() int { return h.statusCode }
+
+// Reason returns the reason sent by the server, empty if it isn't an ApiError
+func (h *HTTPError) Reason() string { return h.reason }
+
+// Message returns the message of the error
+func (h *HTTPError) Message() string { return h.message }
+
+// RetryAfter returns the delay asked by the server through the Retry-After header
+func (h *HTTPError) RetryAfter() time.Duration { return h.retryAfter }
+
+func NewHTTPError(statusCode int, message string, a ...interface{}) *HTTPError {
+	return &HTTPError{statusCode: statusCode, message: fmt.Sprintf(message, a...)}
+}
+
+// newResponseError creates the error of a non successful response
+func newResponseError(statusCode int, body []byte, retryAfter time.Duration) *HTTPError {
+	var apiErr httputil.ApiError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Reason != "" {
+		return &HTTPError{statusCode: statusCode, message: apiErr.Message, reason: apiErr.Reason, retryAfter: retryAfter}
+	}
+	err := NewHTTPError(statusCode, "failed performing request to remote server: %s", string(body))
+	err.retryAfter = retryAfter
+	return err
+}
+
+// transportError is a failure to reach the server
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("failed processing the request [%v]", e.err)
+}
+
+func statusCode(err error) int {
+	if t, ok := err.(*HTTPError); ok {
+		return t.statusCode
+	}
+	return 0
+}
+
+// ReasonForError returns the reason sent by the server, empty if unknown
+func ReasonForError(err error) string {
+	if t, ok := err.(*HTTPError); ok {
+		return t.reason
+	}
+	return ""
 }
 
 func IsNotFound(err error) bool {
-	switch t := err.(type) {
-	case *HTTPError:
-		return t.statusCode == http.StatusNotFound
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsUnauthorized returns true if the server rejected the credentials
+func IsUnauthorized(err error) bool {
+	return statusCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden returns true if the credentials aren't allowed to perform the request
+func IsForbidden(err error) bool {
+	return statusCode(err) == http.StatusForbidden
+}
+
+// IsNotModified returns true if the server answered a conditional request with 304
+func IsNotModified(err error) bool {
+	return statusCode(err) == http.StatusNotModified
+}
+
+// IsTooManyRequests returns true if the server throttled the request
+func IsTooManyRequests(err error) bool {
+	return statusCode(err) == http.StatusTooManyRequests
+}
+
+// IsConflict returns true if the request conflicts with the state of the server
+func IsConflict(err error) bool {
+	return statusCode(err) == http.StatusConflict
+}
+
+// IsTemporary returns true when the request could succeed if retried later,
+// e.g.: the server couldn't be reached or it's overloaded
+func IsTemporary(err error) bool {
+	if _, ok := err.(*transportError); ok {
+		return true
 	}
-	return false
+	return isRetryableStatus(statusCode(err))
 }
@@ -0,0 +1,101 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sparkcorp/allspark/pkg/httputil"
+)
+
+func TestResponseError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api-error":
+			httputil.HttpError(400, httputil.ReasonIngressNotFound).
+				MessageF("ingress %q not found", "web").
+				Write(w)
+		case "/plain":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("internal error"))
+		case "/no-reason":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message": "conflict"}`))
+		}
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+
+	tests := []struct {
+		resource   string
+		statusCode int
+		reason     string
+		message    string
+	}{
+		{"/api-error", 400, httputil.ReasonIngressNotFound, `ingress "web" not found`},
+		{"/plain", 500, "", "failed performing request to remote server: internal error"},
+		{"/no-reason", 409, "", `failed performing request to remote server: {"message": "conflict"}`},
+	}
+	for _, tt := range tests {
+		_, rawErr := New(nil, addr).Resource(tt.resource).Retry(NoRetry).Do().Raw()
+		intoErr := New(nil, addr).Resource(tt.resource).Retry(NoRetry).Do().Into(&struct{}{})
+		for _, err := range []error{rawErr, intoErr} {
+			httpErr, ok := err.(*HTTPError)
+			if !ok {
+				t.Fatalf("%s: expected an *HTTPError, got %T: %v", tt.resource, err, err)
+			}
+			if httpErr.StatusCode() != tt.statusCode || httpErr.Reason() != tt.reason || httpErr.Message() != tt.message {
+				t.Errorf("%s: got [%d] %q %q, want [%d] %q %q", tt.resource,
+					httpErr.StatusCode(), httpErr.Reason(), httpErr.Message(), tt.statusCode, tt.reason, tt.message)
+			}
+			if ReasonForError(err) != tt.reason {
+				t.Errorf("%s: expected the reason %q, got %q", tt.resource, tt.reason, ReasonForError(err))
+			}
+		}
+	}
+	if !strings.HasPrefix(NewHTTPError(400, "bad").Error(), "[400] bad") {
+		t.Errorf("unexpected message: %v", NewHTTPError(400, "bad"))
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		is   func(error) bool
+		want bool
+	}{
+		{"not found", NewHTTPError(404, "missing"), IsNotFound, true},
+		{"unauthorized", NewHTTPError(401, "token"), IsUnauthorized, true},
+		{"forbidden", NewHTTPError(403, "rbac"), IsForbidden, true},
+		{"conflict", NewHTTPError(409, "conflict"), IsConflict, true},
+		{"not found isn't forbidden", NewHTTPError(404, "missing"), IsForbidden, false},
+		{"transport error is temporary", &transportError{err: errors.New("connection refused")}, IsTemporary, true},
+		{"unavailable is temporary", NewHTTPError(503, "unavailable"), IsTemporary, true},
+		{"too many requests is temporary", NewHTTPError(429, "slow down"), IsTemporary, true},
+		{"bad request isn't temporary", NewHTTPError(400, "bad"), IsTemporary, false},
+		{"plain errors aren't typed", errors.New("boom"), IsNotFound, false},
+		{"plain errors aren't temporary", errors.New("boom"), IsTemporary, false},
+	}
+	for _, tt := range tests {
+		if got := tt.is(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if ReasonForError(errors.New("boom")) != "" {
+		t.Errorf("expected plain errors to have no reason")
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr, _ := url.Parse(srv.URL)
+	srv.Close()
+	err := New(nil, addr).Retry(NoRetry).Do().Error()
+	if _, ok := err.(*transportError); !ok || !IsTemporary(err) {
+		t.Errorf("expected a temporary transport error, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,173 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sparkcorp/allspark/pkg/api"
+)
+
+// supportedVersions are the ini-server versions known by the client, newest first
+var supportedVersions = []string{api.IniServerV2, api.IniServerV1}
+
+// IniServerClient is the client of the ini-server, its requests are built
+// from api.IniServerOperations like the routes of the server
+type IniServerClient struct {
+	baseURL *url.URL
+	client  HTTPClient
+	token   TokenSource
+	headers http.Header
+
+	mu       sync.RWMutex
+	versions api.APIVersions
+}
+
+// NewIniServerClient creates a client speaking v1 until Negotiate is called,
+// client and token are optional
+func NewIniServerClient(baseURL *url.URL, client HTTPClient, token TokenSource) *IniServerClient {
+	return &IniServerClient{
+		baseURL:  baseURL,
+		client:   client,
+		token:    token,
+		headers:  http.Header{},
+		versions: api.APIVersions{Versions: []string{api.IniServerV1}, PreferredVersion: api.IniServerV1},
+	}
+}
+
+// URL returns the address of the ini-server
+func (c *IniServerClient) URL() *url.URL {
+	u := *c.baseURL
+	return &u
+}
+
+// SetHeader sets a header sent with every request, it must be
+// called before the client is used
+func (c *IniServerClient) SetHeader(key, value string) {
+	c.headers.Set(key, value)
+}
+
+func (c *IniServerClient) request() *Request {
+	req := New(c.client, c.URL())
+	for key := range c.headers {
+		req.SetHeader(key, c.headers.Get(key))
+	}
+	if c.token != nil {
+		req.BearerToken(c.token)
+	}
+	return req
+}
+
+// Version returns the negotiated version
+func (c *IniServerClient) Version() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, v := range supportedVersions {
+		if c.versions.Supports(v) {
+			return v
+		}
+	}
+	return api.IniServerV1
+}
+
+// HasCapability returns true if the server advertised the capability
+func (c *IniServerClient) HasCapability(capability string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.versions.HasCapability(capability)
+}
+
+// Negotiate discovers the versions served by the ini-server (getAPIVersions),
+// servers without discovery are assumed to serve only v1
+func (c *IniServerClient) Negotiate(timeout time.Duration) error {
+	var versions api.APIVersions
+	err := c.request().
+		Resource(api.IniServerOperation(api.OpGetAPIVersions).Path).
+		SetHeader("Accept", "application/json").
+		Timeout(timeout).
+		Do().Into(&versions)
+	if IsNotFound(err) {
+		versions = api.APIVersions{Versions: []string{api.IniServerV1}, PreferredVersion: api.IniServerV1}
+	} else if err != nil {
+		return fmt.Errorf("failed discovering ini-server versions: %v", err)
+	}
+	c.mu.Lock()
+	c.versions = versions
+	c.mu.Unlock()
+	glog.V(2).Infof("ini-server %s negotiated version %s, capabilities %v", c.baseURL.String(), c.Version(), versions.Capabilities)
+	return nil
+}
+
+// GetVersion fetches the version of the controller (getVersion)
+func (c *IniServerClient) GetVersion(timeout time.Duration) (*api.ServerVersion, error) {
+	var v api.ServerVersion
+	err := c.request().
+		Resource(api.IniServerOperation(api.OpGetVersion).Path).
+		SetHeader("Accept", "application/json").
+		Timeout(timeout).
+		Do().Into(&v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ingressPath returns the path of an operation on an ingress, the
+// operation of v1 is used when it's the negotiated version
+func (c *IniServerClient) ingressPath(op, opV1, namespace, name string) string {
+	if c.Version() == api.IniServerV1 {
+		op = opV1
+	}
+	return api.IniServerOperation(op).Expand("{namespace}", namespace, "{name}", name)
+}
+
+// GetIngressConfig fetches the frpc config of an ingress (getIngressConfig or
+// getIngressIniV1). When hash is the current one the error is a 304, see IsNotModified.
+// The hash of the config is empty when a v1 server doesn't send an ETag.
+func (c *IniServerClient) GetIngressConfig(namespace, name, hash string, timeout time.Duration) (*api.IngressConfig, error) {
+	req := c.request().
+		Resource(c.ingressPath(api.OpGetIngressConfig, api.OpGetIngressIniV1, namespace, name)).
+		Timeout(timeout)
+	if hash != "" {
+		req.SetHeader("If-None-Match", fmt.Sprintf("%q", hash))
+	}
+	version := c.Version()
+	if version != api.IniServerV1 {
+		req.SetHeader("Accept", "application/json")
+	}
+	result := req.Do()
+	if result.Error() == nil && result.StatusCode() == http.StatusNotModified {
+		return nil, NewHTTPError(http.StatusNotModified, "config of ingress %s/%s unchanged", namespace, name)
+	}
+	if version == api.IniServerV1 {
+		data, err := result.Raw()
+		if err != nil {
+			return nil, err
+		}
+		return &api.IngressConfig{
+			APIVersion: api.IniServerV1,
+			Namespace:  namespace,
+			Name:       name,
+			Hash:       strings.Trim(strings.TrimPrefix(result.Header().Get("ETag"), "W/"), `"`),
+			Ini:        string(data),
+		}, nil
+	}
+	var config api.IngressConfig
+	if err := result.Into(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// WatchIngressConfig streams the changes of the config of an ingress
+// (watchIngressConfig or watchIngressV1), the id of each event is a hash
+func (c *IniServerClient) WatchIngressConfig(namespace, name, resumeID string, policy RetryPolicy) *Watcher {
+	return c.request().
+		Resource(c.ingressPath(api.OpWatchIngressConfig, api.OpWatchIngressV1, namespace, name)).
+		Retry(policy).
+		Watch(resumeID)
+}
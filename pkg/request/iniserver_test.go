@@ -0,0 +1,115 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sparkcorp/allspark/pkg/api"
+)
+
+// newIniServer serves the ingress office/web, the versions are served
+// on discovery when not empty
+func newIniServer(t *testing.T, versions *api.APIVersions) *httptest.Server {
+	const hash = "0123456789abcdef"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == fmt.Sprintf("%q", hash) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		switch {
+		case r.URL.Path == "/apis" && versions != nil:
+			json.NewEncoder(w).Encode(versions)
+		case r.URL.Path == "/v1/namespaces/office/ingress/web":
+			w.Header().Set("ETag", fmt.Sprintf("W/%q", hash))
+			w.Write([]byte("[common]\n"))
+		case r.URL.Path == "/v2/namespaces/office/ingresses/web" && versions != nil:
+			json.NewEncoder(w).Encode(&api.IngressConfig{
+				APIVersion: api.IniServerV2,
+				Kind:       "IngressConfig",
+				Namespace:  "office",
+				Name:       "web",
+				Hash:       hash,
+				Ini:        "[common]\n",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestIniServerClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		versions   *api.APIVersions
+		version    string
+		watch      bool
+		apiVersion string
+	}{
+		{
+			name:       "v2 server",
+			versions:   &api.APIVersions{Versions: []string{"v1", "v2"}, PreferredVersion: "v2", Capabilities: []string{api.CapabilityWatch}},
+			version:    api.IniServerV2,
+			watch:      true,
+			apiVersion: api.IniServerV2,
+		},
+		{
+			name:       "server without discovery",
+			version:    api.IniServerV1,
+			apiVersion: api.IniServerV1,
+		},
+		{
+			name:       "newer server",
+			versions:   &api.APIVersions{Versions: []string{"v1", "v3"}, PreferredVersion: "v3"},
+			version:    api.IniServerV1,
+			apiVersion: api.IniServerV1,
+		},
+	}
+	for _, tt := range tests {
+		srv := newIniServer(t, tt.versions)
+		addr, _ := url.Parse(srv.URL)
+		c := NewIniServerClient(addr, nil, nil)
+		if c.Version() != api.IniServerV1 {
+			t.Errorf("%s: expected v1 before negotiating, got %q", tt.name, c.Version())
+		}
+		if err := c.Negotiate(time.Second); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if c.Version() != tt.version || c.HasCapability(api.CapabilityWatch) != tt.watch {
+			t.Errorf("%s: expected version %q and watch=%v, got %q", tt.name, tt.version, tt.watch, c.Version())
+		}
+		config, err := c.GetIngressConfig("office", "web", "", time.Second)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if config.APIVersion != tt.apiVersion || config.Hash != "0123456789abcdef" || config.Ini != "[common]\n" {
+			t.Errorf("%s: unexpected config %#v", tt.name, config)
+		}
+		if _, err := c.GetIngressConfig("office", "web", config.Hash, time.Second); !IsNotModified(err) {
+			t.Errorf("%s: expected a not modified error, got %v", tt.name, err)
+		}
+		if _, err := c.GetIngressConfig("office", "missing", "", time.Second); !IsNotFound(err) {
+			t.Errorf("%s: expected a not found error, got %v", tt.name, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestIniServerClientNegotiateError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+	c := NewIniServerClient(addr, nil, StaticToken("expired"))
+	if err := c.Negotiate(time.Second); err == nil {
+		t.Errorf("expected the discovery to fail")
+	}
+	if c.Version() != api.IniServerV1 {
+		t.Errorf("expected to keep v1, got %q", c.Version())
+	}
+}
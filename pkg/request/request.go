@@ -35,8 +35,11 @@ type HTTPClient interface {
 type Result struct {
 	body        []byte
 	contentType string
+	header      http.Header
 	err         error
 	statusCode  int
+	attempts    int
+	retryAfter  time.Duration
 }
 
 func (r Result) Raw() ([]byte, error) {
@@ -44,8 +47,7 @@ func (r Result) Raw() ([]byte, error) {
 		return nil, r.err
 	}
 	if !r.IsSuccess() {
-		return nil, NewHTTPError(r.statusCode, "failed performing request to remote server: %s", string(r.body))
-		// return nil, fmt.Errorf("response error (%d) from auth0 [%s]", r.StatusCode(), string(r.body))
+		return nil, newResponseError(r.statusCode, r.body, r.retryAfter)
 	}
 	return r.body, nil
 }
@@ -55,8 +57,7 @@ func (r Result) Into(obj interface{}) error {
 		return r.err
 	}
 	if !r.IsSuccess() {
-		return NewHTTPError(r.statusCode, "failed performing request to remote server: %s", string(r.body))
-		// return fmt.Errorf("response error (%d) from auth0 [%s]", r.statusCode, string(r.body))
+		return newResponseError(r.statusCode, r.body, r.retryAfter)
 	}
 	if err := json.Unmarshal(r.body, obj); err != nil {
 		return fmt.Errorf("failed decoding response [%v]", err)
@@ -76,10 +77,36 @@ func (r Result) ContentType() string {
 	return r.contentType
 }
 
+// Header returns the headers of the response
+func (r Result) Header() http.Header {
+	return r.header
+}
+
 func (r Result) Error() error {
 	return r.err
 }
 
+// Attempts returns how many times the request was sent
+func (r Result) Attempts() int {
+	return r.attempts
+}
+
+// RetryAfter returns the delay asked by the server through the Retry-After header
+func (r Result) RetryAfter() time.Duration {
+	return r.retryAfter
+}
+
+// retryable returns true when the request failed with a transient error: the
+// server wasn't reachable or answered a transient status code. Errors building
+// the request, e.g.: retrieving the bearer token, aren't retried.
+func (r Result) retryable() bool {
+	if r.err != nil {
+		_, ok := r.err.(*transportError)
+		return ok
+	}
+	return isRetryableStatus(r.statusCode)
+}
+
 type Request struct {
 	Client HTTPClient
 
@@ -88,13 +115,15 @@ type Request struct {
 	verb       string
 	timeout    time.Duration
 	query      url.Values
+	retry      RetryPolicy
 
 	// This is only used for per-request timeouts, deadlines, and cancellations.
 	ctx context.Context
 
-	body    io.Reader
-	headers http.Header
-	err     error
+	body        []byte
+	headers     http.Header
+	tokenSource TokenSource
+	err         error
 }
 
 func New(client HTTPClient, baseURL *url.URL) *Request {
@@ -107,6 +136,7 @@ func New(client HTTPClient, baseURL *url.URL) *Request {
 		Client:  client,
 		headers: http.Header{"Content-Type": []string{"application/json"}},
 		query:   make(url.Values),
+		retry:   DefaultRetryPolicy,
 	}
 	return request
 }
@@ -130,8 +160,8 @@ func (r *Request) SetHeader(key, value string) *Request {
 	return r
 }
 
-// Timeout makes the request use the given duration as a timeout. Sets the "timeout"
-// parameter.
+// Timeout makes the request use the given duration as a timeout, it
+// bounds all attempts including the delays between retries.
 func (r *Request) Timeout(d time.Duration) *Request {
 	if r.err != nil {
 		return r
@@ -140,6 +170,12 @@ func (r *Request) Timeout(d time.Duration) *Request {
 	return r
 }
 
+// Retry sets the retry policy of the request
+func (r *Request) Retry(policy RetryPolicy) *Request {
+	r.retry = policy
+	return r
+}
+
 // Resource set's the path of the request
 func (r *Request) Resource(basePath string) *Request {
 	r.baseURL.Path = basePath
@@ -194,6 +230,13 @@ func (r *Request) Basic(user, password string) *Request {
 	return r
 }
 
+// BearerToken authenticates the request with a token of the given source,
+// the token is retrieved on each attempt
+func (r *Request) BearerToken(source TokenSource) *Request {
+	r.tokenSource = source
+	return r
+}
+
 func (r *Request) Body(bodyData interface{}) *Request {
 	reqBody, err := json.Marshal(bodyData)
 	if glog.V(6) {
@@ -202,7 +245,7 @@ func (r *Request) Body(bodyData interface{}) *Request {
 	if err != nil {
 		r.err = fmt.Errorf("failed encoding body [%v]", err)
 	}
-	r.body = bytes.NewBuffer(reqBody)
+	r.body = reqBody
 	return r
 }
 
@@ -210,46 +253,104 @@ func (r *Request) Error() error {
 	return r.err
 }
 
+// Do sends the request, idempotent verbs are retried on network errors and
+// transient status codes according to the retry policy of the request.
 func (r *Request) Do() *Result {
-	result := &Result{}
 	if r.err != nil {
-		result.err = r.err
-		return result
+		return &Result{err: r.err}
 	}
 	client := r.Client
 	if r.Client == nil {
 		client = http.DefaultClient
 	}
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	maxAttempts := r.retry.MaxAttempts
+	if maxAttempts < 1 || !isIdempotent(r.verb) {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		result := r.do(ctx, client)
+		result.attempts = attempt
+		if attempt >= maxAttempts || !result.retryable() || ctx.Err() != nil {
+			return result
+		}
+		delay := r.retry.delay(attempt)
+		if result.retryAfter > delay {
+			delay = result.retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return result
+		}
+		glog.V(4).Infof("Retrying %s %s in %v, attempt %d/%d", r.verb, r.URL().String(), delay, attempt+1, maxAttempts)
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(delay):
+		}
+	}
+}
+
+// send performs a single attempt of the request without reading the response
+func (r *Request) send(ctx context.Context, client HTTPClient) (*http.Response, error) {
 	if glog.V(4) {
 		glog.Infof("Verb %#v, URL: %#v, URLPath %#v", r.verb, r.URL().String(), r.URL().Path)
 	}
-
-	request, err := http.NewRequest(r.verb, r.URL().String(), r.body)
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	request, err := http.NewRequest(r.verb, r.URL().String(), body)
 	if err != nil {
-		r.err = fmt.Errorf("failed creating request [%v]", err)
-		return result
+		return nil, fmt.Errorf("failed creating request [%v]", err)
+	}
+	request = request.WithContext(ctx)
+	request.URL.RawQuery = r.query.Encode()
+	for key, values := range r.headers {
+		request.Header[key] = append([]string(nil), values...)
+	}
+	if r.tokenSource != nil {
+		token, err := r.tokenSource.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed retrieving bearer token [%v]", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
 	}
-	q := request.URL.Query()
-	q = r.query
-	request.URL.RawQuery = q.Encode()
-	request.Header = r.headers
 	resp, err := client.Do(request)
 	if err != nil {
-		result.err = fmt.Errorf("failed processing the request [%v]", err)
+		return nil, &transportError{err: err}
+	}
+	return resp, nil
+}
+
+// do performs a single attempt of the request
+func (r *Request) do(ctx context.Context, client HTTPClient) *Result {
+	result := &Result{}
+	resp, err := r.send(ctx, client)
+	if err != nil {
+		result.err = err
 		return result
 	}
 	defer resp.Body.Close()
 
 	result.statusCode = resp.StatusCode
 	result.contentType = resp.Header.Get("Content-Type")
+	result.header = resp.Header
+	result.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
 	if resp.Body != nil {
 		data, err := ioutil.ReadAll(resp.Body)
 		if glog.V(8) {
 			glog.Infof("Response Body[%d]: %s", resp.StatusCode, string(data))
 		}
 		if err != nil {
-			result.err = fmt.Errorf("failed reading response [%v]", err)
-
+			result.err = &transportError{err: fmt.Errorf("failed reading response [%v]", err)}
 			return result
 		}
 		result.body = data
@@ -0,0 +1,143 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer answers with the given status codes in order, the
+// last one is repeated for the remaining requests
+func newFlakyServer(codes ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(codes) {
+			n = len(codes)
+		}
+		w.WriteHeader(codes[n-1])
+		w.Write([]byte("{}"))
+	}))
+	return srv, &calls
+}
+
+func TestDoRetry(t *testing.T) {
+	fast := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	tests := []struct {
+		name     string
+		verb     string
+		policy   RetryPolicy
+		codes    []int
+		attempts int
+		success  bool
+	}{
+		{"recovers from transient errors", "GET", fast, []int{503, 502, 200}, 3, true},
+		{"gives up after max attempts", "GET", fast, []int{503}, 3, false},
+		{"too many requests", "DELETE", fast, []int{429, 200}, 2, true},
+		{"not found isn't transient", "GET", fast, []int{404, 200}, 1, false},
+		{"post isn't idempotent", "POST", fast, []int{503, 200}, 1, false},
+		{"retries disabled", "GET", NoRetry, []int{503, 200}, 1, false},
+	}
+	for _, tt := range tests {
+		srv, calls := newFlakyServer(tt.codes...)
+		addr, _ := url.Parse(srv.URL)
+		result := New(nil, addr).Verb(tt.verb).Retry(tt.policy).Do()
+		srv.Close()
+		if result.Attempts() != tt.attempts || int(*calls) != tt.attempts {
+			t.Errorf("%s: expected %d attempt(s), got %d (%d calls)", tt.name, tt.attempts, result.Attempts(), *calls)
+		}
+		if result.IsSuccess() != tt.success {
+			t.Errorf("%s: expected success=%v, got status %d", tt.name, tt.success, result.StatusCode())
+		}
+	}
+}
+
+// failingToken is a token source which can't read its token
+type failingToken struct{}
+
+func (failingToken) Token() (string, error) { return "", errors.New("token file not found") }
+
+func TestDoRetryErrors(t *testing.T) {
+	fast := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			// the connection is dropped
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			// the body is shorter than announced
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("{}"))
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+
+	result := New(nil, addr).Retry(fast).Do()
+	if result.Error() != nil || result.Attempts() != 3 {
+		t.Errorf("expected the transport errors to be retried, got %d attempt(s): %v", result.Attempts(), result.Error())
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	result = New(nil, addr).Verb("POST").Retry(fast).Do()
+	if !IsTemporary(result.Error()) || result.Attempts() != 1 {
+		t.Errorf("expected a single attempt of a post, got %d attempt(s): %v", result.Attempts(), result.Error())
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	result = New(nil, addr).BearerToken(failingToken{}).Retry(fast).Do()
+	if result.Error() == nil || IsTemporary(result.Error()) || result.Attempts() != 1 || atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("expected the token error to be returned at once, got %d attempt(s): %v", result.Attempts(), result.Error())
+	}
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+
+	// the delay asked by the server doesn't fit in the timeout
+	start := time.Now()
+	result := New(nil, addr).Timeout(time.Second).Do()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected to give up without waiting, took %v", elapsed)
+	}
+	if result.Attempts() != 1 || result.RetryAfter() != 30*time.Second {
+		t.Errorf("expected a single attempt with a retry after of 30s, got %d, %v", result.Attempts(), result.RetryAfter())
+	}
+}
+
+func TestDoTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	addr, _ := url.Parse(srv.URL)
+
+	start := time.Now()
+	if err := New(nil, addr).Timeout(50 * time.Millisecond).Do().Error(); err == nil {
+		t.Errorf("expected the request to time out")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(nil, addr).Context(ctx).Do().Error(); err == nil {
+		t.Errorf("expected the request to be canceled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the requests to be aborted, took %v", elapsed)
+	}
+}
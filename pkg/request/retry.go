@@ -0,0 +1,83 @@
+package request
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how a request is retried, only idempotent
+// verbs are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 1 disables the retries
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles on each attempt
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes each delay by up to this fraction, e.g.: 0.2 is +-20%
+	Jitter float64
+}
+
+var (
+	// DefaultRetryPolicy is used by requests created with New
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     200 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Jitter:      0.2,
+	}
+	// NoRetry performs a single attempt
+	NoRetry = RetryPolicy{MaxAttempts: 1}
+)
+
+// delay returns the time to wait after the given attempt
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(p.Jitter * float64(d) * (2*rand.Float64() - 1))
+	}
+	return d
+}
+
+func isIdempotent(verb string) bool {
+	switch verb {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// isRetryableStatus returns true for status codes of transient failures
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the Retry-After header in seconds or as an http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package request
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", RetryPolicy{Backoff: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{"doubles", RetryPolicy{Backoff: 100 * time.Millisecond}, 3, 400 * time.Millisecond},
+		{"capped", RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}, 3, 300 * time.Millisecond},
+		{"capped on many attempts", RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 1000, 5 * time.Second},
+		{"zero attempt", RetryPolicy{Backoff: time.Second}, 0, time.Second},
+		{"no backoff", RetryPolicy{}, 5, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.delay(tt.attempt); got != tt.want {
+			t.Errorf("%s: delay(%d) = %v, want %v", tt.name, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 4 * time.Second, Jitter: 0.2}
+	for attempt := 1; attempt <= 5; attempt++ {
+		base := RetryPolicy{Backoff: policy.Backoff, MaxBackoff: policy.MaxBackoff}.delay(attempt)
+		min, max := base-base/5, base+base/5
+		for i := 0; i < 100; i++ {
+			if got := policy.delay(attempt); got < min || got > max {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", attempt, got, min, max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "30", 30 * time.Second, 30 * time.Second},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-5", 0, 0},
+		{"invalid", "soon", 0, 0},
+		{"future date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 55 * time.Second, time.Minute},
+		{"past date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("%s: parseRetryAfter(%q) = %v, want between %v and %v", tt.name, tt.value, got, tt.min, tt.max)
+		}
+	}
+}
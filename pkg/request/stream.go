@@ -0,0 +1,166 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Event is a server-sent event, the ID is used as resume token
+type Event struct {
+	ID   string
+	Type string
+	Data []byte
+}
+
+// Stream sends the request and returns the body of the response without reading
+// it, the caller must close it. The timeout of the request isn't applied, cancel
+// its context to stop the stream.
+func (r *Request) Stream() (io.ReadCloser, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	client := r.Client
+	if r.Client == nil {
+		client = http.DefaultClient
+	}
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	resp, err := r.send(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	result := Result{statusCode: resp.StatusCode}
+	if !result.IsSuccess() {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, newResponseError(resp.StatusCode, data, parseRetryAfter(resp.Header.Get("Retry-After")))
+	}
+	return resp.Body, nil
+}
+
+// Watcher decodes the server-sent events of a stream, it reconnects with
+// the ID of the last event as resume token until it's stopped.
+type Watcher struct {
+	req    *Request
+	result chan Event
+	cancel context.CancelFunc
+	lastID string
+}
+
+// Watch streams the events of the request, resumeID is sent as the
+// Last-Event-ID of the first connection when not empty
+func (r *Request) Watch(resumeID string) *Watcher {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r.SetHeader("Accept", "text/event-stream")
+	w := &Watcher{req: r.Context(ctx), result: make(chan Event), cancel: cancel, lastID: resumeID}
+	go w.run(ctx)
+	return w
+}
+
+// ResultChan returns the events, it's closed when the watcher is stopped
+func (w *Watcher) ResultChan() <-chan Event {
+	return w.result
+}
+
+// Stop closes the stream and stops reconnecting
+func (w *Watcher) Stop() {
+	w.cancel()
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.result)
+	policy := w.req.retry
+	if policy.Backoff <= 0 {
+		policy = DefaultRetryPolicy
+	}
+	for attempt := 1; ; attempt++ {
+		if w.lastID != "" {
+			w.req.SetHeader("Last-Event-ID", w.lastID)
+		}
+		body, err := w.req.Stream()
+		if err == nil {
+			var received bool
+			received, err = w.decode(ctx, body)
+			body.Close()
+			if received {
+				attempt = 1
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		delay := policy.delay(attempt)
+		if t, ok := err.(*HTTPError); ok && t.retryAfter > delay {
+			delay = t.retryAfter
+		}
+		glog.V(2).Infof("stream of %s closed (%v), reconnecting in %v", w.req.URL().String(), err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// decode dispatches the events of a text/event-stream body until it's closed,
+// received is true when at least one event was dispatched
+func (w *Watcher) decode(ctx context.Context, body io.Reader) (received bool, err error) {
+	scanner := bufio.NewScanner(body)
+	var event Event
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() == 0 && event.Type == "" {
+				continue
+			}
+			event.Data = bytes.TrimSuffix(data.Bytes(), []byte("\n"))
+			if event.ID != "" {
+				w.lastID = event.ID
+			}
+			select {
+			case w.result <- event:
+				received = true
+			case <-ctx.Done():
+				return received, ctx.Err()
+			}
+			event, data = Event{}, bytes.Buffer{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comments are used as heartbeats
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Type = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, io.EOF
+}
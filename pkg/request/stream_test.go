@@ -0,0 +1,96 @@
+package request
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWatcherDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   []Event
+		lastID string
+	}{
+		{
+			name:   "changed event",
+			body:   "id: abc\nevent: changed\ndata: abc\n\n",
+			want:   []Event{{ID: "abc", Type: "changed", Data: []byte("abc")}},
+			lastID: "abc",
+		},
+		{
+			name: "multi line data",
+			body: "event: error\ndata: {\"reason\":\ndata: \"IngressNotFound\"}\n\n",
+			want: []Event{{Type: "error", Data: []byte("{\"reason\":\n\"IngressNotFound\"}")}},
+		},
+		{
+			name:   "heartbeats and blank lines are skipped",
+			body:   ": heartbeat\n\n\n: heartbeat\n\nid: 1\nevent: changed\ndata: 1\n\n",
+			want:   []Event{{ID: "1", Type: "changed", Data: []byte("1")}},
+			lastID: "1",
+		},
+		{
+			name:   "value without space",
+			body:   "id:2\nevent:changed\ndata:2\n\n",
+			want:   []Event{{ID: "2", Type: "changed", Data: []byte("2")}},
+			lastID: "2",
+		},
+		{
+			name: "unknown fields are ignored",
+			body: "retry: 1000\nevent: changed\ndata: x\n\n",
+			want: []Event{{Type: "changed", Data: []byte("x")}},
+		},
+		{
+			name: "last id is kept by events without id",
+			body: "id: 1\nevent: changed\ndata: 1\n\nevent: error\ndata: {}\n\n",
+			want: []Event{
+				{ID: "1", Type: "changed", Data: []byte("1")},
+				{Type: "error", Data: []byte("{}")},
+			},
+			lastID: "1",
+		},
+		{
+			name: "incomplete event isn't dispatched",
+			body: "id: 3\nevent: changed\ndata: 3\n",
+		},
+	}
+	for _, tt := range tests {
+		w := &Watcher{result: make(chan Event)}
+		var got []Event
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for event := range w.result {
+				got = append(got, event)
+			}
+		}()
+		received, err := w.decode(context.Background(), strings.NewReader(tt.body))
+		close(w.result)
+		<-done
+		if err != io.EOF {
+			t.Errorf("%s: decode() error = %v, want EOF", tt.name, err)
+		}
+		if received != (len(tt.want) > 0) {
+			t.Errorf("%s: decode() received = %v, want %v", tt.name, received, len(tt.want) > 0)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decode() events = %q, want %q", tt.name, got, tt.want)
+		}
+		if w.lastID != tt.lastID {
+			t.Errorf("%s: decode() lastID = %q, want %q", tt.name, w.lastID, tt.lastID)
+		}
+	}
+}
+
+func TestWatcherDecodeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &Watcher{result: make(chan Event)}
+	received, err := w.decode(ctx, strings.NewReader("event: changed\ndata: 1\n\n"))
+	if err != context.Canceled || received {
+		t.Errorf("decode() = %v, %v, want false, %v", received, err, context.Canceled)
+	}
+}
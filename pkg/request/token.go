@@ -0,0 +1,63 @@
+package request
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceAccountTokenFile is the token mounted on pods by Kubernetes
+const ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// TokenSource provides the bearer token sent on each request
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a token which never changes
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// fileTokenSource reads a token from a file, the token is cached for a period
+// so rotated tokens (e.g. projected ServiceAccount tokens) are picked up
+type fileTokenSource struct {
+	path   string
+	period time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewFileTokenSource creates a source reading the token of the given file,
+// it's read again after the period
+func NewFileTokenSource(path string, period time.Duration) TokenSource {
+	return &fileTokenSource{path: path, period: period}
+}
+
+func (s *fileTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if s.token != "" {
+			// keep the last token, the file could be in the middle of a rotation
+			return s.token, nil
+		}
+		return "", fmt.Errorf("failed reading token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %q is empty", s.path)
+	}
+	s.token, s.expires = token, time.Now().Add(s.period)
+	return s.token, nil
+}
@@ -0,0 +1,71 @@
+package request
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTokenSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	if _, err := NewFileTokenSource(path, time.Hour).Token(); err == nil {
+		t.Errorf("expected an error for a missing token file")
+	}
+	ioutil.WriteFile(path, []byte("  \n"), 0600)
+	if _, err := NewFileTokenSource(path, time.Hour).Token(); err == nil {
+		t.Errorf("expected an error for an empty token file")
+	}
+
+	ioutil.WriteFile(path, []byte("first\n"), 0600)
+	cached := NewFileTokenSource(path, time.Hour)
+	rotated := NewFileTokenSource(path, 0)
+	for _, source := range []TokenSource{cached, rotated} {
+		if token, err := source.Token(); err != nil || token != "first" {
+			t.Fatalf("expected the token %q, got %q, %v", "first", token, err)
+		}
+	}
+	ioutil.WriteFile(path, []byte("second"), 0600)
+	if token, _ := cached.Token(); token != "first" {
+		t.Errorf("expected the token to be cached for the period, got %q", token)
+	}
+	if token, _ := rotated.Token(); token != "second" {
+		t.Errorf("expected the rotated token after the period, got %q", token)
+	}
+	// the last token is kept while the file is rotated
+	os.Remove(path)
+	if token, err := rotated.Token(); err != nil || token != "second" {
+		t.Errorf("expected the last token, got %q, %v", token, err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+	addr, _ := url.Parse(srv.URL)
+
+	if err := New(nil, addr).BearerToken(StaticToken("secret")).Do().Error(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("expected the bearer token header, got %q", auth)
+	}
+	auth = ""
+	missing := NewFileTokenSource(filepath.Join(os.TempDir(), "missing-token"), time.Hour)
+	if err := New(nil, addr).BearerToken(missing).Do().Error(); err == nil || auth != "" {
+		t.Errorf("expected the request to fail without reaching the server, got %v", err)
+	}
+}
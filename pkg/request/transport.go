@@ -0,0 +1,74 @@
+package request
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TLSConfig configures the trust and the identity of a client
+type TLSConfig struct {
+	// CAFile is a PEM bundle used to verify the server, the system
+	// roots are used when it's empty
+	CAFile string
+	// CertFile and KeyFile are the client certificate, they're read on each
+	// handshake so rotated certificates are picked up without restarting
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// IsEmpty returns true if nothing was configured
+func (c TLSConfig) IsEmpty() bool {
+	return c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && c.ServerName == ""
+}
+
+// NewTLSClient creates an http client verifying the server with the given CA bundle
+// and presenting a client certificate when configured
+func NewTLSClient(cfg TLSConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ca bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if cfg.CertFile != "" {
+		// fail fast on a missing or invalid pair
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed loading client certificate: %v", err)
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed loading client certificate: %v", err)
+			}
+			return &cert, nil
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        10,
+		},
+	}, nil
+}
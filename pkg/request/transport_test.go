@@ -0,0 +1,118 @@
+package request
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate and its key in dir
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewTLSClientErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "client")
+	_, otherKey := writeCert(t, dir, "other")
+	garbage := filepath.Join(dir, "garbage.pem")
+	ioutil.WriteFile(garbage, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+	}{
+		{"missing ca", TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{"invalid ca", TLSConfig{CAFile: garbage}},
+		{"cert without key", TLSConfig{CertFile: certFile}},
+		{"key without cert", TLSConfig{KeyFile: keyFile}},
+		{"mismatched pair", TLSConfig{CertFile: certFile, KeyFile: otherKey}},
+	}
+	for _, tt := range tests {
+		if _, err := NewTLSClient(tt.cfg); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	if !(TLSConfig{}).IsEmpty() || (TLSConfig{ServerName: "ini-server"}).IsEmpty() {
+		t.Errorf("expected only the zero config to be empty")
+	}
+}
+
+func TestNewTLSClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "client")
+	clientCA, _ := ioutil.ReadFile(certFile)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(clientCA)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	addr, _ := url.Parse(srv.URL)
+
+	client, err := NewTLSClient(TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := New(client, addr).Retry(NoRetry).Do().Raw()
+	if err != nil || string(body) != "client" {
+		t.Errorf("expected the server to verify the client certificate, got %q, %v", body, err)
+	}
+
+	// the server isn't trusted without its ca
+	client, err = NewTLSClient(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := New(client, addr).Retry(NoRetry).Do().Error(); err == nil {
+		t.Errorf("expected the server certificate to be rejected")
+	}
+}
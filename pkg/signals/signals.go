@@ -0,0 +1,29 @@
+package signals
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+var onlyOneSignalHandler = make(chan struct{})
+
+// SetupSignalHandler returns a channel which is closed on SIGTERM or SIGINT.
+// A second signal terminates the program with exit code 1.
+func SetupSignalHandler() <-chan struct{} {
+	close(onlyOneSignalHandler) // panics when called twice
+
+	stop := make(chan struct{})
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-c
+		glog.Infof("Received signal %v, shutting down ...", sig)
+		close(stop)
+		<-c
+		os.Exit(1) // second signal, exit directly
+	}()
+	return stop
+}
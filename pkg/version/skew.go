@@ -0,0 +1,177 @@
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Header carries the version of the client, e.g.: the ini-sync binary
+	Header = "X-Allspark-Version"
+	// FRPHeader carries the version of frp bundled with the client
+	FRPHeader = "X-Allspark-Frp-Version"
+	// NodeHeader identifies the node of a kubelet ini-sync
+	NodeHeader = "X-Allspark-Node"
+)
+
+// Semver is a semantic version, e.g.: v0.20.0 or 0.0.1-rc.2
+type Semver struct {
+	Major, Minor, Patch int
+	Pre                 []string
+}
+
+// ParseSemver parses a semantic version, the 'v' prefix and build metadata are optional
+func ParseSemver(s string) (Semver, error) {
+	var v Semver
+	raw := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(raw, "+"); i >= 0 {
+		raw = raw[:i]
+	}
+	if i := strings.Index(raw, "-"); i >= 0 {
+		v.Pre = strings.Split(raw[i+1:], ".")
+		raw = raw[:i]
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*dst = n
+	}
+	return v, nil
+}
+
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than o,
+// pre-releases are lower than its release
+func (v Semver) Compare(o Semver) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePre(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.Pre) - len(o.Pre))
+}
+
+// comparePre compares identifiers of pre-releases, numeric ones are lower
+func comparePre(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return sign(na - nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+type constraint struct {
+	op      string
+	version Semver
+}
+
+// Range is a set of constraints that must all match, e.g.: ">=0.20.0 <0.21.0"
+type Range struct {
+	raw         string
+	constraints []constraint
+}
+
+// ParseRange parses space separated constraints with the operators >=, >, <=, < and =,
+// an empty range matches any version
+func ParseRange(s string) (*Range, error) {
+	r := &Range{raw: strings.TrimSpace(s)}
+	for _, field := range strings.Fields(s) {
+		op := strings.TrimRight(field, "v0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		switch op {
+		case ">=", ">", "<=", "<", "=":
+		case "":
+			op = "="
+		default:
+			return nil, fmt.Errorf("invalid constraint %q", field)
+		}
+		v, err := ParseSemver(strings.TrimPrefix(field, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %v", field, err)
+		}
+		r.constraints = append(r.constraints, constraint{op: op, version: v})
+	}
+	return r, nil
+}
+
+// Contains returns true if the version matches all constraints of the range
+func (r *Range) Contains(v Semver) bool {
+	for _, c := range r.constraints {
+		cmp := v.Compare(c.version)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Range) String() string {
+	return r.raw
+}
+
+// SyncerRange is the default range of ini-sync versions supported by a controller:
+// the same minor release, not newer than the controller. Nil when the version of
+// the controller isn't a release, e.g.: a development build.
+func SyncerRange(controllerVersion string) *Range {
+	v, err := ParseSemver(controllerVersion)
+	if err != nil {
+		return nil
+	}
+	r, _ := ParseRange(fmt.Sprintf(">=%d.%d.0-0 <=%s", v.Major, v.Minor, v.String()))
+	return r
+}
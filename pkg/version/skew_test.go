@@ -0,0 +1,150 @@
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Semver
+		wantErr bool
+	}{
+		{in: "0.20.0", want: Semver{Major: 0, Minor: 20, Patch: 0}},
+		{in: "v1.2.3", want: Semver{Major: 1, Minor: 2, Patch: 3}},
+		{in: " v1.2.3 ", want: Semver{Major: 1, Minor: 2, Patch: 3}},
+		{in: "0.0.1-rc.2", want: Semver{Patch: 1, Pre: []string{"rc", "2"}}},
+		{in: "1.0.0+build.5", want: Semver{Major: 1}},
+		{in: "1.0.0-beta+exp.sha", want: Semver{Major: 1, Pre: []string{"beta"}}},
+		{in: "", wantErr: true},
+		{in: "1.2", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+		{in: "1.x.3", wantErr: true},
+		{in: "1.-2.3", wantErr: true},
+		{in: "dev", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSemver(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSemver(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSemver(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.2.0", "1.1.9", 1},
+		{"1.1.2", "1.1.10", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.0.0-0", "1.0.0-alpha", -1},
+		{"v0.20.0", "0.20.0", 0},
+	}
+	for _, tt := range tests {
+		a, _ := ParseSemver(tt.a)
+		b, _ := ParseSemver(tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		contains []string
+		excludes []string
+		wantErr  bool
+	}{
+		{in: "", contains: []string{"0.0.1", "99.0.0"}},
+		{in: ">=0.20.0 <0.21.0", contains: []string{"0.20.0", "0.20.9"}, excludes: []string{"0.19.9", "0.21.0", "0.20.0-rc.1"}},
+		{in: ">0.20.0", contains: []string{"0.20.1"}, excludes: []string{"0.20.0"}},
+		{in: "<=v1.0.0", contains: []string{"1.0.0", "1.0.0-rc.1"}, excludes: []string{"1.0.1"}},
+		{in: "=0.20.0", contains: []string{"0.20.0"}, excludes: []string{"0.20.1"}},
+		{in: "0.20.0", contains: []string{"0.20.0"}, excludes: []string{"0.20.1"}},
+		{in: "~0.20.0", wantErr: true},
+		{in: ">=0.20", wantErr: true},
+		{in: ">= 0.20.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRange(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		for _, v := range tt.contains {
+			if s, _ := ParseSemver(v); !r.Contains(s) {
+				t.Errorf("ParseRange(%q) doesn't contain %s", tt.in, v)
+			}
+		}
+		for _, v := range tt.excludes {
+			if s, _ := ParseSemver(v); r.Contains(s) {
+				t.Errorf("ParseRange(%q) contains %s", tt.in, v)
+			}
+		}
+	}
+}
+
+func TestSyncerRange(t *testing.T) {
+	tests := []struct {
+		controller string
+		want       string
+		contains   []string
+		excludes   []string
+	}{
+		{
+			controller: "v0.1.2",
+			want:       ">=0.1.0-0 <=0.1.2",
+			contains:   []string{"0.1.0", "0.1.0-rc.1", "0.1.2"},
+			excludes:   []string{"0.0.9", "0.1.3", "0.2.0"},
+		},
+		{
+			controller: "0.0.1-rc.2",
+			want:       ">=0.0.0-0 <=0.0.1-rc.2",
+			contains:   []string{"0.0.1-rc.1", "0.0.1-rc.2"},
+			excludes:   []string{"0.0.1", "0.1.0"},
+		},
+	}
+	for _, tt := range tests {
+		r := SyncerRange(tt.controller)
+		if r == nil {
+			t.Errorf("SyncerRange(%q) = nil", tt.controller)
+			continue
+		}
+		if r.String() != tt.want {
+			t.Errorf("SyncerRange(%q) = %q, want %q", tt.controller, r.String(), tt.want)
+		}
+		for _, v := range tt.contains {
+			if s, _ := ParseSemver(v); !r.Contains(s) {
+				t.Errorf("SyncerRange(%q) doesn't contain %s", tt.controller, v)
+			}
+		}
+		for _, v := range tt.excludes {
+			if s, _ := ParseSemver(v); r.Contains(s) {
+				t.Errorf("SyncerRange(%q) contains %s", tt.controller, v)
+			}
+		}
+	}
+	for _, dev := range []string{"", "dev", "git-abcdef"} {
+		if r := SyncerRange(dev); r != nil {
+			t.Errorf("SyncerRange(%q) = %q, want nil", dev, r.String())
+		}
+	}
+}
@@ -15,13 +15,16 @@ type Info struct {
 	GoVersion string `json:"go_version"`
 	Compiler  string `json:"compiler"`
 	Platform  string `json:"platform"`
+	// FRPVersion is the version of the frp binaries shipped with the image
+	FRPVersion string `json:"frp_version,omitempty"`
 }
 
 var (
 	version string
 	// gitVersion string
-	gitCommit = "$Format:%H$"          // sha1 from git, output of $(git rev-parse HEAD)
-	buildDate = "1970-01-01T00:00:00Z" // build date in ISO8601 format, output of $(date -u +'%Y-%m-%dT%H:%M:%SZ')
+	gitCommit  = "$Format:%H$"          // sha1 from git, output of $(git rev-parse HEAD)
+	buildDate  = "1970-01-01T00:00:00Z" // build date in ISO8601 format, output of $(date -u +'%Y-%m-%dT%H:%M:%SZ')
+	frpVersion string                   // release of frp built into the image, see rootfs/Dockerfile
 )
 
 // Get returns the overall codebase version. It's for detecting
@@ -30,12 +33,13 @@ func Get() Info {
 	// These variables typically come from -ldflags settings and in
 	// their absence fallback to the settings in pkg/version/base.go
 	return Info{
-		Version:   version,
-		GitCommit: gitCommit,
-		BuildDate: buildDate,
-		GoVersion: runtime.Version(),
-		Compiler:  runtime.Compiler,
-		Platform:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		Version:    version,
+		GitCommit:  gitCommit,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+		Compiler:   runtime.Compiler,
+		Platform:   fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		FRPVersion: frpVersion,
 	}
 }
 
@@ -48,9 +52,20 @@ func Print() {
 	fmt.Println("Git Commit: ", vinfo.GitCommit)
 	fmt.Println("GO Version: ", vinfo.GoVersion)
 	fmt.Println("Platform: ", vinfo.Platform)
+	fmt.Println("FRP Version: ", vinfo.FRPVersion)
 	fmt.Println("----------------------------------")
 }
 
+// UserAgent identifies a component and its version on requests
+func UserAgent(component string) string {
+	vinfo := Get()
+	v := vinfo.Version
+	if v == "" {
+		v = "unknown"
+	}
+	return fmt.Sprintf("%s/%s (%s) %s", component, v, vinfo.Platform, vinfo.GitCommit)
+}
+
 func PrintAndExit() {
 	Print()
 	os.Exit(0)
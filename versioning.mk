@@ -3,6 +3,8 @@ MUTABLE_VERSION ?= latest
 VERSION ?= ${GIT_TAG}
 GITCOMMIT ?= $(shell git rev-parse HEAD)
 DATE ?= $(shell date -u "+%Y-%m-%dT%H:%M:%SZ")
+# the release of frp built into the image, it's reported by the binaries
+FRP_VERSION ?= v0.20.0
 
 REGISTRY ?= quay.io
 IMAGE_PREFIX ?= sandromello